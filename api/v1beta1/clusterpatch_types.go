@@ -0,0 +1,60 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,categories={patches}
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"ResourcePatched\")].status",description=""
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.conditions[?(@.type==\"ResourcePatched\")].reason",description=""
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+
+// ClusterPatch is the Schema for the clusterpatches API
+type ClusterPatch struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PatchSpec   `json:"spec,omitempty"`
+	Status PatchStatus `json:"status,omitempty"`
+}
+
+// GetSpec return the spec of the ClusterPatch
+func (p *ClusterPatch) GetSpec() *PatchSpec {
+	return &p.Spec
+}
+
+// GetStatus return the status of the ClusterPatch
+func (p *ClusterPatch) GetStatus() *PatchStatus {
+	return &p.Status
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterPatchList contains a list of ClusterPatch
+type ClusterPatchList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterPatch `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterPatch{}, &ClusterPatchList{})
+}
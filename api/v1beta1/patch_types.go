@@ -18,7 +18,9 @@ package v1beta1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -27,16 +29,305 @@ type SynchronizationSpec struct {
 	Time string `json:"time"`
 }
 
+// TemplateContextType defines the shape of the data given to the template
+// +kubebuilder:validation:Enum=Legacy;Structured
+type TemplateContextType string
+
+const (
+	// TemplateContextLegacy gives a list with the target followed by the sources, in the order they are defined
+	TemplateContextLegacy TemplateContextType = "Legacy"
+
+	// TemplateContextStructured gives an object with the target, the sources by alias, the Patch and the values
+	TemplateContextStructured TemplateContextType = "Structured"
+)
+
+// TemplateEngine defines the engine rendering the patches from the templates
+// +kubebuilder:validation:Enum=template;cel;jsonnet;jq
+type TemplateEngine string
+
+const (
+	// TemplateEngineTemplate renders Go templates with Sprig functions producing YAML
+	TemplateEngineTemplate TemplateEngine = "template"
+
+	// TemplateEngineCEL evaluates CEL expressions producing structured objects
+	TemplateEngineCEL TemplateEngine = "cel"
+
+	// TemplateEngineJsonnet evaluates Jsonnet programs producing JSON documents
+	TemplateEngineJsonnet TemplateEngine = "jsonnet"
+
+	// TemplateEngineJq runs jq programs producing JSON documents
+	TemplateEngineJq TemplateEngine = "jq"
+)
+
+// DeletionPolicy defines what happens to the targets when the Patch is deleted
+// +kubebuilder:validation:Enum=Retain;Revert
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain keeps the targets as they are
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+
+	// DeletionPolicyRevert restores the fields touched on the targets to their previous values
+	DeletionPolicyRevert DeletionPolicy = "Revert"
+)
+
+// TargetPolicy defines what happens when the target of the Patch does not exist
+// +kubebuilder:validation:Enum=Patch;CreateIfMissing
+type TargetPolicy string
+
+const (
+	// TargetPolicyPatch only patches existing targets, failing when they are not found
+	TargetPolicyPatch TargetPolicy = "Patch"
+
+	// TargetPolicyCreateIfMissing creates the target from the rendered template when it is not found
+	TargetPolicyCreateIfMissing TargetPolicy = "CreateIfMissing"
+)
+
+// ServerSideApplySpec defines the behavior of patches performed using server-side apply
+type ServerSideApplySpec struct {
+
+	// FieldManager is the name of the manager owning the applied fields.
+	// It is derived from the kind, namespace and name of the Patch when empty
+	FieldManager string `json:"fieldManager,omitempty"`
+
+	// Force takes the ownership of the fields already owned by other managers, instead of failing on conflicts
+	Force bool `json:"force,omitempty"`
+}
+
+// SourceSelectorSpec defines how to select several objects of the same kind as a source
+type SourceSelectorSpec struct {
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	FieldSelector string                `json:"fieldSelector,omitempty"`
+
+	// AllNamespaces looks for the objects in all the namespaces instead of only in the namespace of the source
+	AllNamespaces bool `json:"allNamespaces,omitempty"`
+}
+
+// SourceSpec defines an object, or a list of objects, whose content is given to the template
+type SourceSpec struct {
+	corev1.ObjectReference `json:",inline"`
+
+	// Alias is the name used to access the source inside the Structured template context
+	Alias string `json:"alias,omitempty"`
+
+	// Optional sources do not fail the synchronization when they are not found.
+	// Their Default is given to the template instead, or nothing when it is not defined
+	Optional bool                  `json:"optional,omitempty"`
+	Default  *apiextensionsv1.JSON `json:"default,omitempty"`
+
+	// Selector gets all the objects of the kind matching it, instead of a single object by name.
+	// They are given to the template as a list
+	Selector *SourceSelectorSpec `json:"selector,omitempty"`
+}
+
+// PatchStepSpec defines one of the patches performed in order against each target
+type PatchStepSpec struct {
+
+	// Name identifies the step in the status when it fails. Its position is used when empty
+	Name string `json:"name,omitempty"`
+
+	Template  string          `json:"template"`
+	PatchType types.PatchType `json:"patchType"`
+}
+
+// ReplacementOptions defines how to take, or replace, only a part of a string value
+type ReplacementOptions struct {
+
+	// Delimiter splits the value into parts. The whole value is used when empty
+	Delimiter string `json:"delimiter,omitempty"`
+
+	// Index is the position of the part to take, or replace, when the value is split
+	// +kubebuilder:validation:Minimum=0
+	Index int `json:"index,omitempty"`
+
+	// Create makes the target field when it does not exist. It is only used by targets
+	Create bool `json:"create,omitempty"`
+}
+
+// ReplacementSourceSpec defines the field of a source whose value is copied into the target
+type ReplacementSourceSpec struct {
+
+	// Alias of the source to copy the value from
+	Alias string `json:"alias"`
+
+	// FieldPath is the path of the field, as dot-separated keys. List items are selected by their position,
+	// or as '[key=value]'. Keys containing dots are written as '[key]'. It is 'metadata.name' when empty
+	FieldPath string `json:"fieldPath,omitempty"`
+
+	Options *ReplacementOptions `json:"options,omitempty"`
+}
+
+// ReplacementTargetSpec defines the fields of the target where the value of the source is copied
+type ReplacementTargetSpec struct {
+
+	// FieldPaths are the paths of the fields, written as the FieldPath of the source
+	FieldPaths []string `json:"fieldPaths"`
+
+	Options *ReplacementOptions `json:"options,omitempty"`
+}
+
+// ReplacementSpec defines a value copied from a source into the target, as Kustomize replacements do
+type ReplacementSpec struct {
+	Source  ReplacementSourceSpec   `json:"source"`
+	Targets []ReplacementTargetSpec `json:"targets"`
+}
+
+// TargetSelectorSpec defines a set of targets selected by their labels
+type TargetSelectorSpec struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	// NamespaceSelector selects the namespaces where the targets live. When empty, namespaced Patches
+	// look for targets in their own namespace, while ClusterPatches look for them in all the namespaces
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// LabelSelector selects the targets. All the objects of the kind are selected when empty
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+}
+
 // PatchSpec defines the desired state of Patch
 type PatchSpec struct {
 
 	// SynchronizationSpec defines the behavior of synchronization
 	Synchronization SynchronizationSpec `json:"synchronization"`
 
-	Sources   []corev1.ObjectReference `json:"sources"`
-	Target    corev1.ObjectReference   `json:"target"`
-	Template  string                   `json:"template"`
-	PatchType types.PatchType          `json:"patchType"`
+	Sources []SourceSpec           `json:"sources"`
+	Target  corev1.ObjectReference `json:"target,omitempty"`
+
+	// TargetSelector selects several targets to patch with the same template, one by one.
+	// Exactly one of Target and TargetSelector must be defined
+	TargetSelector *TargetSelectorSpec `json:"targetSelector,omitempty"`
+
+	// When is a CEL expression evaluated for each target before patching it. Targets are skipped when it is false.
+	// It can use the variables 'target', 'sources' (by alias), 'resources' (as in the Legacy context), 'patch' and 'values'
+	When string `json:"when,omitempty"`
+
+	// TargetPolicy defines what happens when the target does not exist. Patch is used when empty.
+	// With CreateIfMissing, the rendered template is used as the whole manifest of the missing target
+	TargetPolicy TargetPolicy `json:"targetPolicy,omitempty"`
+
+	// SetOwnerReference adds an ownerReference to the Patch on the targets it creates,
+	// so they are garbage collected with it
+	SetOwnerReference bool `json:"setOwnerReference,omitempty"`
+
+	// AdmissionMutation patches the targets right when they are created, through a mutating webhook,
+	// instead of waiting for the next synchronization. Synchronizations keep patching them as usual
+	AdmissionMutation bool `json:"admissionMutation,omitempty"`
+
+	Template  string          `json:"template,omitempty"`
+	PatchType types.PatchType `json:"patchType,omitempty"`
+
+	// Replacements copy values from the sources into the target without templates.
+	// They take precedence over Steps, Template and PatchType when defined
+	Replacements []ReplacementSpec `json:"replacements,omitempty"`
+
+	// Steps are several patches performed in order against each target, each one rendered with the target
+	// as it is after the previous step. They take precedence over Template and PatchType when defined
+	Steps []PatchStepSpec `json:"steps,omitempty"`
+
+	// Engine defines how the templates are rendered into patches. Go templates are used when empty.
+	// With cel, each template is a CEL expression returning the patch as an object, or as a list for JSON patches.
+	// With jsonnet, each template is a Jsonnet program receiving the data as external variables.
+	// With jq, each template is a jq program receiving the data as its input
+	Engine TemplateEngine `json:"engine,omitempty"`
+
+	// JsonnetLibraries are the files that Jsonnet programs can import, indexed by their path
+	JsonnetLibraries map[string]string `json:"jsonnetLibraries,omitempty"`
+
+	// TemplateContext defines the data given to the template. Legacy is used when empty
+	// to keep existing Patches working
+	TemplateContext TemplateContextType `json:"templateContext,omitempty"`
+
+	// Values are arbitrary data given to the template in the Structured context
+	Values *apiextensionsv1.JSON `json:"values,omitempty"`
+
+	// ServerSideApply defines the behavior of patches with type 'application/apply-patch+yaml'
+	ServerSideApply *ServerSideApplySpec `json:"serverSideApply,omitempty"`
+
+	// DeletionPolicy defines what happens to the targets when the Patch is deleted. Retain is used when empty
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// TargetEvents emits the events about the synchronization of each target on the target itself too
+	TargetEvents bool `json:"targetEvents,omitempty"`
+
+	// ServiceAccountName is the name of the ServiceAccount impersonated to get the sources and patch the target.
+	// The identity of the controller is used when empty
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// ServiceAccountNamespace is the namespace of the impersonated ServiceAccount. It is only used by ClusterPatches,
+	// as namespaced Patches always impersonate ServiceAccounts from their own namespace
+	ServiceAccountNamespace string `json:"serviceAccountNamespace,omitempty"`
+}
+
+// TargetStatus defines the result of patching one of the targets
+type TargetStatus struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+
+	Status  metav1.ConditionStatus `json:"status"`
+	Reason  string                 `json:"reason"`
+	Message string                 `json:"message,omitempty"`
+
+	// Step is the step that failed, when the Patch is defined by steps
+	Step string `json:"step,omitempty"`
+
+	// ResourceVersion is the version of the target after the last synchronization
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	// Drifted is true when the patched fields were changed by others since the previous synchronization
+	Drifted bool `json:"drifted,omitempty"`
+
+	// PatchHash is the hash of the patches applied on the target
+	PatchHash string `json:"patchHash,omitempty"`
+
+	// AppliedHash is the hash of the values of the patched fields right after patching them,
+	// and AppliedPaths are the JSON pointers to those fields
+	AppliedHash  string   `json:"appliedHash,omitempty"`
+	AppliedPaths []string `json:"appliedPaths,omitempty"`
+}
+
+// SourceStatus defines the version of one of the objects used as source.
+// Sources with selector are recorded once, with a digest of the versions of all the objects they selected
+type SourceStatus struct {
+	APIVersion      string `json:"apiVersion"`
+	Kind            string `json:"kind"`
+	Namespace       string `json:"namespace,omitempty"`
+	Name            string `json:"name,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+
+	// LabelSelector and FieldSelector identify the sources with selector
+	LabelSelector string `json:"labelSelector,omitempty"`
+	FieldSelector string `json:"fieldSelector,omitempty"`
+
+	// Selected is the number of objects selected, and Digest is a hash of their namespaces, names and versions
+	Selected int    `json:"selected,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+}
+
+// RevertPath defines the value of a field of the target before it was patched for the first time
+type RevertPath struct {
+
+	// Path is the JSON pointer to the field. List items are identified by their merge key, as 'k:{"name":"app"}',
+	// or by their value in lists of scalars, as 'v:"value"', like Kubernetes does in the managed fields
+	Path string `json:"path"`
+
+	// Value is the previous value of the field. The field did not exist when empty
+	Value *apiextensionsv1.JSON `json:"value,omitempty"`
+}
+
+// TargetRevertStatus defines what is needed to revert the changes done to a target
+type TargetRevertStatus struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+
+	// Created is true when the target was created by the Patch, so it is deleted instead of restored
+	Created bool `json:"created,omitempty"`
+
+	Paths []RevertPath `json:"paths"`
 }
 
 // PatchStatus defines the observed state of Patch
@@ -44,6 +335,50 @@ type PatchStatus struct {
 
 	// Conditions represent the latest available observations of an object's state
 	Conditions []metav1.Condition `json:"conditions"`
+
+	// ObservedGeneration is the generation of the Patch seen by the last synchronization
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastSyncTime is the last time the Patch was synchronized, and LastSuccessfulSyncTime the last time it succeeded
+	LastSyncTime           *metav1.Time `json:"lastSyncTime,omitempty"`
+	LastSuccessfulSyncTime *metav1.Time `json:"lastSuccessfulSyncTime,omitempty"`
+
+	// ConsecutiveFailures is the number of synchronizations failed since the last successful one
+	ConsecutiveFailures int64 `json:"consecutiveFailures,omitempty"`
+
+	// LastAppliedHash is the hash of the patches applied on all the targets by the last successful synchronization
+	LastAppliedHash string `json:"lastAppliedHash,omitempty"`
+
+	// Sources represent the versions of the sources used by the last synchronization
+	Sources []SourceStatus `json:"sources,omitempty"`
+
+	// Targets represent the result of the last synchronization for each patched target
+	Targets []TargetStatus `json:"targets,omitempty"`
+
+	// AppliedSyncCount is the number of times a target was patched
+	AppliedSyncCount int64 `json:"appliedSyncCount,omitempty"`
+
+	// UnchangedSyncCount is the number of times a target was not patched, as the patches would not change it
+	UnchangedSyncCount int64 `json:"unchangedSyncCount,omitempty"`
+
+	// DriftCount is the number of times the patched fields were found changed by others, and patched again
+	DriftCount int64 `json:"driftCount,omitempty"`
+
+	// LastDriftTime is the last time the patched fields were found changed by others
+	LastDriftTime *metav1.Time `json:"lastDriftTime,omitempty"`
+
+	// Reverts store the previous values of the fields touched on the targets, when DeletionPolicy is Revert
+	Reverts []TargetRevertStatus `json:"reverts,omitempty"`
+}
+
+// PatchObject is implemented by every kind whose spec is a PatchSpec, so all of them are synchronized the same way
+// +kubebuilder:object:generate=false
+type PatchObject interface {
+	metav1.Object
+	runtime.Object
+
+	GetSpec() *PatchSpec
+	GetStatus() *PatchStatus
 }
 
 //+kubebuilder:object:root=true
@@ -62,6 +397,16 @@ type Patch struct {
 	Status PatchStatus `json:"status,omitempty"`
 }
 
+// GetSpec return the spec of the Patch
+func (p *Patch) GetSpec() *PatchSpec {
+	return &p.Spec
+}
+
+// GetStatus return the status of the Patch
+func (p *Patch) GetStatus() *PatchStatus {
+	return &p.Status
+}
+
 //+kubebuilder:object:root=true
 
 // PatchList contains a list of Patch
@@ -0,0 +1,80 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReferenceUsage defines how a referenced object is used by a Patch
+// +kubebuilder:validation:Enum=Source;Target
+type ReferenceUsage string
+
+const (
+	ReferenceUsageSource ReferenceUsage = "Source"
+	ReferenceUsageTarget ReferenceUsage = "Target"
+)
+
+// ReferenceGrantFrom defines the namespaces whose Patches are allowed to reference the objects
+type ReferenceGrantFrom struct {
+	Namespace string `json:"namespace"`
+}
+
+// ReferenceGrantTo defines the objects that can be referenced from other namespaces
+type ReferenceGrantTo struct {
+
+	// Group of the referenced kind. Empty for the core group
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+
+	// Name of the referenced object. All the objects of the kind are allowed when empty
+	Name string `json:"name,omitempty"`
+
+	// Usage restricts the objects to be used only as Source or Target. Both are allowed when empty
+	Usage ReferenceUsage `json:"usage,omitempty"`
+}
+
+// ReferenceGrantSpec defines the desired state of ReferenceGrant
+type ReferenceGrantSpec struct {
+	From []ReferenceGrantFrom `json:"from"`
+	To   []ReferenceGrantTo   `json:"to"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Namespaced,categories={patches}
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+
+// ReferenceGrant allows Patches from other namespaces to reference objects in the namespace where it lives
+type ReferenceGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReferenceGrantSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ReferenceGrantList contains a list of ReferenceGrant
+type ReferenceGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReferenceGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReferenceGrant{}, &ReferenceGrantList{})
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
@@ -33,6 +34,8 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"prosimcorp.com/reforma/internal/controller"
+	"prosimcorp.com/reforma/internal/sandbox"
+	"prosimcorp.com/reforma/internal/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -49,14 +52,32 @@ func init() {
 }
 
 func main() {
+	// Template engines run their programs in copies of this binary, which must not start the manager
+	sandbox.Init()
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var allowCrossNamespaceReferences bool
+	var maxSelectedTargets int
+	var tracingOptions tracing.Options
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&allowCrossNamespaceReferences, "allow-cross-namespace-references", false,
+		"Allow namespaced Patches to reference objects outside their own namespace. "+
+			"Use ClusterPatches instead when possible.")
+	flag.IntVar(&maxSelectedTargets, "max-selected-targets", controller.DefaultMaxSelectedTargets,
+		"The number of targets a target selector can select at most, as all of them are recorded in the status.")
+	flag.StringVar(&tracingOptions.Endpoint, "tracing-endpoint", "",
+		"The address of the OTLP gRPC collector receiving the traces of the reconciliations. "+
+			"Tracing is disabled when empty.")
+	flag.BoolVar(&tracingOptions.Insecure, "tracing-insecure", false,
+		"Connect to the OTLP collector without TLS, as usual for local collectors.")
+	flag.Float64Var(&tracingOptions.SamplingRatio, "tracing-sampling-ratio", 1,
+		"The ratio of reconciliations traced, from 0 to 1.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,6 +86,17 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingOptions)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			setupLog.Error(err, "unable to flush the pending traces")
+		}
+	}()
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
@@ -88,13 +120,43 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controller.PatchReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	patchReconciler := &controller.PatchReconciler{
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		Recorder:                      mgr.GetEventRecorderFor("reforma"),
+		AllowCrossNamespaceReferences: allowCrossNamespaceReferences,
+		MaxSelectedTargets:            maxSelectedTargets,
+	}
+	if err = patchReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Patch")
 		os.Exit(1)
 	}
+	clusterPatchReconciler := &controller.ClusterPatchReconciler{
+		PatchReconciler: controller.PatchReconciler{
+			Client:             mgr.GetClient(),
+			Scheme:             mgr.GetScheme(),
+			Recorder:           mgr.GetEventRecorderFor("reforma"),
+			MaxSelectedTargets: maxSelectedTargets,
+		},
+	}
+	if err = clusterPatchReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterPatch")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = patchReconciler.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Patch")
+			os.Exit(1)
+		}
+		if err = clusterPatchReconciler.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterPatch")
+			os.Exit(1)
+		}
+		if err = patchReconciler.SetupTargetWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "targets")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
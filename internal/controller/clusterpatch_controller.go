@@ -0,0 +1,57 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// ClusterPatchReconciler reconciles a ClusterPatch object.
+// It reuses all the synchronization logic from PatchReconciler
+type ClusterPatchReconciler struct {
+	PatchReconciler
+}
+
+//+kubebuilder:rbac:groups=reforma.prosimcorp.com,resources=clusterpatches,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=reforma.prosimcorp.com,resources=clusterpatches/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=reforma.prosimcorp.com,resources=clusterpatches/finalizers,verbs=update
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+func (r *ClusterPatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	return r.ReconcilePatchObject(ctx, req, &reformav1beta1.ClusterPatch{})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterPatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return r.SetupPatchObjectWithManager(mgr, r, &reformav1beta1.ClusterPatch{}, &reformav1beta1.ClusterPatchList{})
+}
+
+//+kubebuilder:webhook:path=/validate-reforma-prosimcorp-com-v1beta1-clusterpatch,mutating=false,failurePolicy=fail,sideEffects=None,groups=reforma.prosimcorp.com,resources=clusterpatches,verbs=create;update,versions=v1beta1,name=vclusterpatch.reforma.prosimcorp.com,admissionReviewVersions=v1
+
+// SetupWebhookWithManager sets up the webhook validating the ClusterPatches with the Manager.
+// They are validated the same way as Patches
+func (r *ClusterPatchReconciler) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&reformav1beta1.ClusterPatch{}).
+		WithValidator(r).
+		Complete()
+}
@@ -0,0 +1,323 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// admissionWebhookPath is the path where the webhook patching the targets on their creation is served
+	admissionWebhookPath = "/mutate-reforma-prosimcorp-com-targets"
+
+	// admissionRenderTimeout is the time the Patches have to be applied on admission. It is shorter than the timeout
+	// of the webhook, so the API server receives the warnings of the Patches that did not finish in time
+	admissionRenderTimeout = 2 * time.Second
+
+	admissionPatchesListError = "Can not list the Patches to apply on admission"
+	admissionForbiddenError   = "ServiceAccount %s is not allowed to patch %s %s: %s"
+	admissionPatchError       = "Patch %s/%s could not be applied on admission"
+	admissionPatchWarning     = "Reforma could not apply the Patch %s/%s on admission, it will be applied by the next synchronization: %s"
+)
+
+// admittedTargetKey is the key of the context value holding the target being admitted
+type admittedTargetKey struct{}
+
+//+kubebuilder:webhook:path=/mutate-reforma-prosimcorp-com-targets,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=configmaps;secrets;serviceaccounts,verbs=create,versions=v1,name=mtarget.reforma.prosimcorp.com,admissionReviewVersions=v1,reinvocationPolicy=IfNeeded,timeoutSeconds=3
+
+// targetMutator patches the targets of the Patches with admission mutation enabled when they are created
+type targetMutator struct {
+	*PatchReconciler
+}
+
+// SetupTargetWebhookWithManager sets up the webhook patching the targets on their creation with the Manager.
+// It serves both Patches and ClusterPatches
+func (r *PatchReconciler) SetupTargetWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(admissionWebhookPath, &webhook.Admission{Handler: &targetMutator{r}})
+	return nil
+}
+
+// getAdmissionPatches return all the Patches and ClusterPatches with admission mutation enabled.
+// Their status is changed while rendering their patches, but it is never written
+func (m *targetMutator) getAdmissionPatches(ctx context.Context) (patchManifests []reformav1beta1.PatchObject, err error) {
+	patchList := &reformav1beta1.PatchList{}
+	err = m.List(ctx, patchList)
+	if err != nil {
+		return patchManifests, err
+	}
+
+	clusterPatchList := &reformav1beta1.ClusterPatchList{}
+	err = m.List(ctx, clusterPatchList)
+	if err != nil {
+		return patchManifests, err
+	}
+
+	candidates := []reformav1beta1.PatchObject{}
+	for i := range patchList.Items {
+		candidates = append(candidates, &patchList.Items[i])
+	}
+	for i := range clusterPatchList.Items {
+		candidates = append(candidates, &clusterPatchList.Items[i])
+	}
+
+	for _, candidate := range candidates {
+		if candidate.GetSpec().AdmissionMutation && candidate.GetDeletionTimestamp().IsZero() {
+			patchManifests = append(patchManifests, candidate)
+		}
+	}
+
+	return patchManifests, err
+}
+
+// isAdmissionTarget return whether an object being created is one of the targets of a Patch.
+// The client of the Patch is only built when its namespace selector must be resolved
+func (m *targetMutator) isAdmissionTarget(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	target *unstructured.Unstructured) (isTarget bool, err error) {
+
+	targetSelector := patchManifest.GetSpec().TargetSelector
+	if targetSelector == nil {
+		reference := patchManifest.GetSpec().Target
+
+		// Cluster-scoped targets have no namespace
+		namespaceMatches := target.GetNamespace() == "" || target.GetNamespace() == getReferenceNamespace(patchManifest, reference)
+		return reference.APIVersion == target.GetAPIVersion() && reference.Kind == target.GetKind() &&
+			reference.Name == target.GetName() && namespaceMatches, err
+	}
+
+	if targetSelector.APIVersion != target.GetAPIVersion() || targetSelector.Kind != target.GetKind() {
+		return isTarget, err
+	}
+
+	selector, err := getSelector(targetSelector.LabelSelector)
+	if err != nil || !selector.Matches(labels.Set(target.GetLabels())) {
+		return isTarget, err
+	}
+
+	// Namespaced Patches look for targets in their own namespace by default
+	if targetSelector.NamespaceSelector == nil {
+		return patchManifest.GetNamespace() == "" || target.GetNamespace() == patchManifest.GetNamespace(), err
+	}
+
+	// Cluster-scoped targets are not filtered by namespace
+	if target.GetNamespace() == "" {
+		return true, err
+	}
+
+	patchClient, err := m.GetPatchClient(patchManifest)
+	if err != nil {
+		return isTarget, err
+	}
+
+	namespaces, err := m.getTargetNamespaces(ctx, patchClient, targetSelector)
+	return namespaces[target.GetNamespace()], err
+}
+
+// checkAdmissionPermitted check that the ServiceAccount of a Patch can patch the target being admitted.
+// Targets are patched by the API server on admission, not by the client impersonating the ServiceAccount,
+// so its permissions are reviewed to limit the Patch as its synchronizations are
+func (m *targetMutator) checkAdmissionPermitted(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	target *unstructured.Unstructured) (err error) {
+
+	serviceAccountName := patchManifest.GetSpec().ServiceAccountName
+	if serviceAccountName == "" {
+		return err
+	}
+
+	gvk := target.GroupVersionKind()
+	mapping, err := m.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+
+	serviceAccountNamespace := getServiceAccountNamespace(patchManifest)
+	username := fmt.Sprintf(serviceAccountUsernameFormat, serviceAccountNamespace, serviceAccountName)
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   username,
+			Groups: []string{serviceAccountsGroup, fmt.Sprintf(serviceAccountsNamespaceGroupFormat, serviceAccountNamespace)},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: target.GetNamespace(),
+				Verb:      "patch",
+				Group:     mapping.Resource.Group,
+				Version:   mapping.Resource.Version,
+				Resource:  mapping.Resource.Resource,
+				Name:      target.GetName(),
+			},
+		},
+	}
+
+	err = m.Create(ctx, review)
+	if err != nil {
+		return err
+	}
+
+	if !review.Status.Allowed {
+		return NewErrorf(admissionForbiddenError, username, target.GetKind(), target.GetName(), review.Status.Reason)
+	}
+
+	return err
+}
+
+// applyAdmissionPatch apply a rendered patch on a target being admitted. Strategic merge patches fall back to
+// merge patches for kinds without Go types, as Kubernetes does, and applied patches are merged strategically
+func (m *targetMutator) applyAdmissionPatch(target *unstructured.Unstructured, patchType types.PatchType, patch []byte) (err error) {
+	original, err := json.Marshal(target.Object)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	switch patchType {
+	case types.JSONPatchType:
+		decodedPatch, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return err
+		}
+		patched, err = decodedPatch.Apply(original)
+		if err != nil {
+			return err
+		}
+	case types.MergePatchType:
+		patched, err = jsonpatch.MergePatch(original, patch)
+	default:
+		typedObject, schemeErr := m.Scheme.New(target.GroupVersionKind())
+		if schemeErr != nil {
+			patched, err = jsonpatch.MergePatch(original, patch)
+		} else {
+			patched, err = strategicpatch.StrategicMergePatch(original, patch, typedObject)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	patchedObject := map[string]interface{}{}
+	err = json.Unmarshal(patched, &patchedObject)
+	if err != nil {
+		return err
+	}
+
+	target.Object = patchedObject
+	return err
+}
+
+// patchAdmittedTarget render all the steps of a Patch against a target being admitted, applying them in order
+func (m *targetMutator) patchAdmittedTarget(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	target *unstructured.Unstructured) (err error) {
+
+	patchClient, err := m.GetPatchClient(patchManifest)
+	if err != nil {
+		return err
+	}
+
+	err = m.checkAdmissionPermitted(ctx, patchManifest, target)
+	if err != nil {
+		return err
+	}
+
+	ctx = context.WithValue(ctx, admittedTargetKey{}, target)
+
+	targetReference := corev1.ObjectReference{
+		APIVersion: target.GetAPIVersion(),
+		Kind:       target.GetKind(),
+		Namespace:  target.GetNamespace(),
+		Name:       target.GetName(),
+	}
+
+	// The sources are got once, and shared by the 'when' expression and all the steps
+	resources, err := m.GetResources(ctx, patchClient, patchManifest, targetReference)
+	if err != nil {
+		return err
+	}
+
+	patchable, err := m.EvaluateWhen(patchManifest, resources)
+	if err != nil || !patchable {
+		return err
+	}
+
+	// Each step is rendered against the target patched by the previous ones
+	for _, step := range GetPatchSteps(patchManifest) {
+		resources[0] = target.Object
+
+		patch, err := m.GetPatch(ctx, patchManifest, step, targetReference, resources)
+		if err != nil {
+			return err
+		}
+
+		parsedPatch, err := yaml.YAMLToJSON([]byte(patch))
+		if err != nil {
+			return err
+		}
+
+		err = m.applyAdmissionPatch(target, step.PatchType, parsedPatch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Handle patch the objects being created with all the Patches targeting them with admission mutation enabled.
+// Objects are always admitted: failing Patches are reported as warnings, and applied later by their synchronization
+func (m *targetMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+
+	target := &unstructured.Unstructured{}
+	err := json.Unmarshal(req.Object.Raw, &target.Object)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// Objects can be created without namespace, taking it from the request
+	if target.GetNamespace() == "" {
+		target.SetNamespace(req.Namespace)
+	}
+
+	// The Patches must be applied before the API server gives up on the webhook
+	ctx, cancel := context.WithTimeout(ctx, admissionRenderTimeout)
+	defer cancel()
+
+	patchManifests, err := m.getAdmissionPatches(ctx)
+	if err != nil {
+		LogErrorf(ctx, err, admissionPatchesListError)
+		return admission.Allowed("")
+	}
+
+	var warnings []string
+	for _, patchManifest := range patchManifests {
+		isTarget, err := m.isAdmissionTarget(ctx, patchManifest, target)
+		if err == nil && isTarget {
+			err = m.patchAdmittedTarget(ctx, patchManifest, target)
+		}
+
+		if err != nil {
+			LogErrorf(ctx, err, admissionPatchError, patchManifest.GetNamespace(), patchManifest.GetName())
+			warnings = append(warnings, fmt.Sprintf(admissionPatchWarning,
+				patchManifest.GetNamespace(), patchManifest.GetName(), err.Error()))
+		}
+	}
+
+	patchedTarget, err := json.Marshal(target.Object)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, patchedTarget).WithWarnings(warnings...)
+}
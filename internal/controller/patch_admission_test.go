@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// newAdmittedTarget return a ConfigMap being admitted, as the webhook decodes it
+func newAdmittedTarget(t *testing.T, namespace string, name string, labels map[string]string) *unstructured.Unstructured {
+	t.Helper()
+
+	rawTarget, err := json.Marshal(&corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+	})
+	if err != nil {
+		t.Fatalf("can not encode the target: %v", err)
+	}
+
+	return &unstructured.Unstructured{Object: decodeObject(t, string(rawTarget))}
+}
+
+func TestIsAdmissionTarget(t *testing.T) {
+	selected := map[string]string{"selected": "true"}
+	selector := &reformav1beta1.TargetSelectorSpec{
+		APIVersion:    "v1",
+		Kind:          "ConfigMap",
+		LabelSelector: &metav1.LabelSelector{MatchLabels: selected},
+	}
+	namespaceSelector := &reformav1beta1.TargetSelectorSpec{
+		APIVersion:        "v1",
+		Kind:              "ConfigMap",
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: selected},
+	}
+
+	namedPatch := newValidPatch()
+	selectorPatch := newValidPatch()
+	selectorPatch.Spec.Target, selectorPatch.Spec.TargetSelector = corev1.ObjectReference{}, selector
+	clusterPatch := &reformav1beta1.ClusterPatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "patch"},
+		Spec:       reformav1beta1.PatchSpec{TargetSelector: selector},
+	}
+	namespaceSelectorPatch := &reformav1beta1.ClusterPatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "patch"},
+		Spec:       reformav1beta1.PatchSpec{TargetSelector: namespaceSelector},
+	}
+
+	tests := []struct {
+		name   string
+		patch  reformav1beta1.PatchObject
+		target *unstructured.Unstructured
+		want   bool
+	}{
+		{
+			name:   "target with the name of the Patch",
+			patch:  namedPatch,
+			target: newAdmittedTarget(t, "default", "target", nil),
+			want:   true,
+		},
+		{
+			name:   "target with other name",
+			patch:  namedPatch,
+			target: newAdmittedTarget(t, "default", "other", nil),
+		},
+		{
+			name:   "target in other namespace",
+			patch:  namedPatch,
+			target: newAdmittedTarget(t, "other", "target", nil),
+		},
+		{
+			name:   "target matching the selector",
+			patch:  selectorPatch,
+			target: newAdmittedTarget(t, "default", "other", selected),
+			want:   true,
+		},
+		{
+			name:   "target not matching the selector",
+			patch:  selectorPatch,
+			target: newAdmittedTarget(t, "default", "other", nil),
+		},
+		{
+			name:   "target matching the selector outside the namespace of the Patch",
+			patch:  selectorPatch,
+			target: newAdmittedTarget(t, "other", "other", selected),
+		},
+		{
+			name:   "target matching the selector of a ClusterPatch in any namespace",
+			patch:  clusterPatch,
+			target: newAdmittedTarget(t, "other", "other", selected),
+			want:   true,
+		},
+		{
+			name:   "target in a selected namespace",
+			patch:  namespaceSelectorPatch,
+			target: newAdmittedTarget(t, "selected", "other", nil),
+			want:   true,
+		},
+		{
+			name:   "target in a namespace not selected",
+			patch:  namespaceSelectorPatch,
+			target: newAdmittedTarget(t, "default", "other", nil),
+		},
+	}
+
+	m := &targetMutator{&PatchReconciler{
+		Client: fake.NewClientBuilder().
+			WithScheme(newTestScheme(t)).
+			WithRESTMapper(newTestRESTMapper()).
+			WithObjects(
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "selected", Labels: selected}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			).
+			Build(),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isTarget, err := m.isAdmissionTarget(context.Background(), test.patch, test.target)
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if isTarget != test.want {
+				t.Errorf("got %t, want %t", isTarget, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyAdmissionPatch(t *testing.T) {
+	pod := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"pod"},
+		"spec":{"containers":[{"name":"app","image":"app:1"},{"name":"sidecar","image":"sidecar:1"}]}}`
+	configMap := `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config"},"data":{"key":"value"}}`
+	custom := `{"apiVersion":"example.com/v1","kind":"Custom","metadata":{"name":"custom"},"spec":{"items":[{"name":"a"}]}}`
+
+	tests := []struct {
+		name      string
+		target    string
+		patchType types.PatchType
+		patch     string
+		want      string
+	}{
+		{
+			name:      "JSON patch",
+			target:    configMap,
+			patchType: types.JSONPatchType,
+			patch:     `[{"op":"add","path":"/data/other","value":"added"}]`,
+			want:      `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config"},"data":{"key":"value","other":"added"}}`,
+		},
+		{
+			name:      "merge patch",
+			target:    configMap,
+			patchType: types.MergePatchType,
+			patch:     `{"data":{"key":null,"other":"added"}}`,
+			want:      `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"config"},"data":{"other":"added"}}`,
+		},
+		{
+			name:      "strategic merge patch merges lists by their key",
+			target:    pod,
+			patchType: types.StrategicMergePatchType,
+			patch:     `{"spec":{"containers":[{"name":"app","image":"app:2"}]}}`,
+			want: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"pod"},
+				"spec":{"containers":[{"name":"app","image":"app:2"},{"name":"sidecar","image":"sidecar:1"}]}}`,
+		},
+		{
+			name:      "applied patch is merged strategically",
+			target:    pod,
+			patchType: types.ApplyPatchType,
+			patch:     `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"pod"},"spec":{"containers":[{"name":"sidecar","image":"sidecar:2"}]}}`,
+			want: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"pod"},
+				"spec":{"containers":[{"name":"app","image":"app:1"},{"name":"sidecar","image":"sidecar:2"}]}}`,
+		},
+		{
+			name:      "strategic merge patch of kinds without Go types is a merge patch",
+			target:    custom,
+			patchType: types.StrategicMergePatchType,
+			patch:     `{"spec":{"items":[{"name":"b"}]}}`,
+			want:      `{"apiVersion":"example.com/v1","kind":"Custom","metadata":{"name":"custom"},"spec":{"items":[{"name":"b"}]}}`,
+		},
+	}
+
+	m := &targetMutator{&PatchReconciler{Scheme: newTestScheme(t)}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &unstructured.Unstructured{Object: decodeObject(t, test.target)}
+
+			err := m.applyAdmissionPatch(target, test.patchType, []byte(test.patch))
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			want := decodeObject(t, test.want)
+			if !reflect.DeepEqual(target.Object, want) {
+				t.Errorf("got %v, want %v", target.Object, want)
+			}
+		})
+	}
+}
+
+func TestCheckAdmissionPermitted(t *testing.T) {
+	tests := []struct {
+		name           string
+		serviceAccount string
+		allowed        bool
+		wantReview     bool
+		wantErr        bool
+	}{
+		{
+			name: "Patch without ServiceAccount",
+		},
+		{
+			name:           "ServiceAccount allowed to patch the target",
+			serviceAccount: "patcher",
+			allowed:        true,
+			wantReview:     true,
+		},
+		{
+			name:           "ServiceAccount not allowed to patch the target",
+			serviceAccount: "patcher",
+			wantReview:     true,
+			wantErr:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reviews []authorizationv1.SubjectAccessReviewSpec
+
+			m := &targetMutator{&PatchReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(newTestScheme(t)).
+					WithRESTMapper(newTestRESTMapper()).
+					WithInterceptorFuncs(interceptor.Funcs{
+						Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+							review := obj.(*authorizationv1.SubjectAccessReview)
+							reviews = append(reviews, review.Spec)
+							review.Status.Allowed = test.allowed
+							return nil
+						},
+					}).
+					Build(),
+			}}
+
+			patchManifest := newValidPatch()
+			patchManifest.Spec.ServiceAccountName = test.serviceAccount
+
+			err := m.checkAdmissionPermitted(context.Background(), patchManifest, newAdmittedTarget(t, "default", "target", nil))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if !test.wantReview {
+				if len(reviews) != 0 {
+					t.Errorf("got reviews %+v, want none", reviews)
+				}
+				return
+			}
+
+			wantReview := authorizationv1.SubjectAccessReviewSpec{
+				User:   "system:serviceaccount:default:patcher",
+				Groups: []string{"system:serviceaccounts", "system:serviceaccounts:default"},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: "default",
+					Verb:      "patch",
+					Version:   "v1",
+					Resource:  "configmaps",
+					Name:      "target",
+				},
+			}
+			if len(reviews) != 1 || !reflect.DeepEqual(reviews[0], wantReview) {
+				t.Errorf("got reviews %+v, want %+v", reviews, wantReview)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// fieldManagerPrefix is the prefix of the field managers derived from the Patch
+	fieldManagerPrefix = "reforma"
+
+	// fieldManagerMaxLength is the maximum length of a field manager accepted by Kubernetes
+	fieldManagerMaxLength = 128
+)
+
+// GetFieldManager return the field manager used to apply the patches of a Patch
+func GetFieldManager(patchManifest reformav1beta1.PatchObject) (fieldManager string) {
+	if serverSideApply := patchManifest.GetSpec().ServerSideApply; serverSideApply != nil && serverSideApply.FieldManager != "" {
+		return serverSideApply.FieldManager
+	}
+
+	fieldManager = strings.Join([]string{fieldManagerPrefix, patchManifest.GetNamespace(), patchManifest.GetName()}, "/")
+	if patchManifest.GetNamespace() == "" {
+		fieldManager = strings.Join([]string{fieldManagerPrefix, patchManifest.GetName()}, "/")
+	}
+
+	if len(fieldManager) > fieldManagerMaxLength {
+		fieldManager = fieldManager[:fieldManagerMaxLength]
+	}
+
+	return fieldManager
+}
+
+// getApplyOptions return the options to perform server-side apply for a Patch with the given field manager
+func getApplyOptions(patchManifest reformav1beta1.PatchObject, fieldManager string) (options []client.PatchOption) {
+	options = append(options, client.FieldOwner(fieldManager))
+
+	if serverSideApply := patchManifest.GetSpec().ServerSideApply; serverSideApply != nil && serverSideApply.Force {
+		options = append(options, client.ForceOwnership)
+	}
+
+	return options
+}
+
+// completeTargetManifest fill the fields identifying the target inside an apply patch or a manifest to create it,
+// when they are missing. This way, templates only need to contain the fields to apply
+func completeTargetManifest(patch []byte, target *unstructured.Unstructured) (completedPatch []byte, err error) {
+	applyObject := &unstructured.Unstructured{Object: map[string]interface{}{}}
+
+	err = json.Unmarshal(patch, &applyObject.Object)
+	if err != nil {
+		return completedPatch, err
+	}
+
+	if applyObject.GetAPIVersion() == "" {
+		applyObject.SetAPIVersion(target.GetAPIVersion())
+	}
+	if applyObject.GetKind() == "" {
+		applyObject.SetKind(target.GetKind())
+	}
+	if applyObject.GetName() == "" {
+		applyObject.SetName(target.GetName())
+	}
+	if applyObject.GetNamespace() == "" {
+		applyObject.SetNamespace(target.GetNamespace())
+	}
+
+	return json.Marshal(applyObject.Object)
+}
+
+// updateApplyConflictCondition set the ApplyConflict reason on the Patch when the error of an applied step
+// comes from fields owned by other managers. It returns whether the condition was updated
+func (r *PatchReconciler) updateApplyConflictCondition(patchManifest reformav1beta1.PatchObject,
+	step reformav1beta1.PatchStepSpec, err error) bool {
+	if step.PatchType != types.ApplyPatchType || !apierrors.IsConflict(err) {
+		return false
+	}
+
+	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+		metav1.ConditionFalse,
+		ConditionReasonApplyConflict,
+		fmt.Sprintf(ConditionReasonApplyConflictMessage, err.Error()),
+	))
+	return true
+}
+
+// releaseTargetFields release the fields owned by a field manager of the Patch on a target.
+// On Revert policy, the fields are removed applying an empty object. Otherwise, they are kept without owner
+func (r *PatchReconciler) releaseTargetFields(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject,
+	target *unstructured.Unstructured, fieldManager string) (err error) {
+
+	if patchManifest.GetSpec().DeletionPolicy == reformav1beta1.DeletionPolicyRevert {
+		emptyPatch, err := completeTargetManifest([]byte("{}"), target)
+		if err != nil {
+			return err
+		}
+		return patchClient.Patch(ctx, target, client.RawPatch(types.ApplyPatchType, emptyPatch), client.FieldOwner(fieldManager))
+	}
+
+	err = patchClient.Get(ctx, client.ObjectKeyFromObject(target), target)
+	if err != nil {
+		return err
+	}
+
+	managedFields := []metav1.ManagedFieldsEntry{}
+	for _, entry := range target.GetManagedFields() {
+		if entry.Manager == fieldManager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			continue
+		}
+		managedFields = append(managedFields, entry)
+	}
+
+	if len(managedFields) == len(target.GetManagedFields()) {
+		return err
+	}
+
+	// An empty list does not clear the managed fields, a list with an empty entry does
+	var releasePatch []byte
+	if len(managedFields) == 0 {
+		releasePatch, err = json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"managedFields": []interface{}{map[string]interface{}{}}},
+		})
+	} else {
+		releasePatch, err = json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"managedFields": managedFields},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return patchClient.Patch(ctx, target, client.RawPatch(types.MergePatchType, releasePatch))
+}
+
+// ReleaseAppliedFields release the fields owned by the applied steps of the Patch on all the targets of its last synchronization.
+// Targets that do not exist anymore are considered released. Targets the Patch is not allowed to change anymore
+// keep the Patch, unless it is annotated to abandon them
+func (r *PatchReconciler) ReleaseAppliedFields(ctx context.Context, patchManifest reformav1beta1.PatchObject) (err error) {
+
+	patchClient, err := r.GetPatchClient(patchManifest)
+	if err != nil {
+		return err
+	}
+
+	var releaseErrors []error
+	for _, targetStatus := range patchManifest.GetStatus().Targets {
+		target := &unstructured.Unstructured{}
+		target.SetGroupVersionKind((&corev1.ObjectReference{
+			APIVersion: targetStatus.APIVersion,
+			Kind:       targetStatus.Kind,
+		}).GroupVersionKind())
+		target.SetNamespace(targetStatus.Namespace)
+		target.SetName(targetStatus.Name)
+
+		for _, fieldManager := range getApplyFieldManagers(patchManifest) {
+			err = r.releaseTargetFields(ctx, patchClient, patchManifest, target, fieldManager)
+			if err != nil && isTargetRevertAbandoned(patchManifest, err) {
+				r.abandonTargetRevert(ctx, patchManifest, target, err)
+				break
+			}
+			if err != nil {
+				releaseErrors = append(releaseErrors, err)
+			}
+		}
+	}
+
+	err = errors.Join(releaseErrors...)
+	if err == nil {
+		return err
+	}
+
+	if slices.ContainsFunc(releaseErrors, apierrors.IsForbidden) {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonRevertForbidden,
+			fmt.Sprintf(ConditionReasonRevertForbiddenMessage, abandonRevertAnnotation),
+		))
+		return err
+	}
+
+	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+		metav1.ConditionFalse,
+		ConditionReasonFieldsReleaseFailed,
+		ConditionReasonFieldsReleaseFailedMessage,
+	))
+	return err
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestGetFieldManager(t *testing.T) {
+	longName := strings.Repeat("a", 200)
+
+	tests := []struct {
+		name  string
+		patch reformav1beta1.PatchObject
+		want  string
+	}{
+		{
+			name:  "namespaced Patch",
+			patch: &reformav1beta1.Patch{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "patch"}},
+			want:  "reforma/default/patch",
+		},
+		{
+			name:  "ClusterPatch",
+			patch: &reformav1beta1.ClusterPatch{ObjectMeta: metav1.ObjectMeta{Name: "patch"}},
+			want:  "reforma/patch",
+		},
+		{
+			name: "custom field manager",
+			patch: &reformav1beta1.Patch{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "patch"},
+				Spec: reformav1beta1.PatchSpec{
+					ServerSideApply: &reformav1beta1.ServerSideApplySpec{FieldManager: "custom"},
+				},
+			},
+			want: "custom",
+		},
+		{
+			name:  "long names are truncated",
+			patch: &reformav1beta1.Patch{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: longName}},
+			want:  ("reforma/default/" + longName)[:fieldManagerMaxLength],
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fieldManager := GetFieldManager(test.patch)
+			if fieldManager != test.want {
+				t.Errorf("got %s, want %s", fieldManager, test.want)
+			}
+			if len(fieldManager) > fieldManagerMaxLength {
+				t.Errorf("got a field manager of %d characters, want %d at most", len(fieldManager), fieldManagerMaxLength)
+			}
+		})
+	}
+}
+
+func TestReleaseAppliedFields(t *testing.T) {
+	forbidden := func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, key.Name, nil)
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		funcs       interceptor.Funcs
+		wantErr     bool
+		wantReason  string
+	}{
+		{
+			name: "missing targets are released",
+		},
+		{
+			name:       "forbidden targets keep the Patch",
+			funcs:      interceptor.Funcs{Get: forbidden},
+			wantErr:    true,
+			wantReason: ConditionReasonRevertForbidden,
+		},
+		{
+			name:        "forbidden targets are abandoned when annotated",
+			annotations: map[string]string{abandonRevertAnnotation: "true"},
+			funcs:       interceptor.Funcs{Get: forbidden},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patchManifest := newValidPatch()
+			patchManifest.Annotations = test.annotations
+			patchManifest.Spec.PatchType = types.ApplyPatchType
+			patchManifest.Status.Targets = []reformav1beta1.TargetStatus{
+				{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "target"},
+			}
+
+			recorder := record.NewFakeRecorder(10)
+			r := &PatchReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithInterceptorFuncs(test.funcs).Build(),
+				Recorder: recorder,
+			}
+
+			err := r.ReleaseAppliedFields(context.Background(), patchManifest)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched)
+			if test.wantErr && (condition == nil || condition.Reason != test.wantReason) {
+				t.Errorf("got condition %+v, want reason %s", condition, test.wantReason)
+			}
+
+			// Only abandoned targets are reported, as missing ones have nothing left to release
+			wantEvents := 0
+			if test.annotations != nil {
+				wantEvents = 1
+			}
+			if len(recorder.Events) != wantEvents {
+				t.Errorf("got %d events, want %d", len(recorder.Events), wantEvents)
+			}
+		})
+	}
+}
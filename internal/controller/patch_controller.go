@@ -18,15 +18,27 @@ package controller
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
 
+	"go.opentelemetry.io/otel/attribute"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
@@ -39,6 +51,8 @@ const (
 	patchConditionUpdateError   = "Failed to update the condition on Patch: %s"
 	patchSyncTimeRetrievalError = "Can not get synchronization time from the Patch: %s"
 	patchTargetError            = "Can not patch the target for the Patch: %s"
+	patchRevertError            = "Can not revert or release the targets of the Patch: %s"
+	patchWatchError             = "Can not watch the referenced kinds for the Patch: %s. Relying on synchronization time"
 
 	patchFinalizer = "reforma.prosimcorp.com/finalizer"
 )
@@ -47,11 +61,33 @@ const (
 type PatchReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Recorder emits the events about the synchronizations, on the Patches and optionally on their targets
+	Recorder record.EventRecorder
+
+	// AllowCrossNamespaceReferences let namespaced Patches reference objects outside their own namespace
+	AllowCrossNamespaceReferences bool
+
+	// MaxSelectedTargets is the number of targets a selector can select at most, as all of them are recorded in the
+	// status of the Patch. DefaultMaxSelectedTargets is used when it is not greater than zero
+	MaxSelectedTargets int
+
+	// controller and cache are kept to register watches on the kinds referenced by Patches at runtime
+	controller        controller.Controller
+	cache             cache.Cache
+	restConfig        *rest.Config
+	patchListType     client.ObjectList
+	watchedKinds      map[schema.GroupVersionKind]bool
+	watchedKindsMutex sync.Mutex
 }
 
 //+kubebuilder:rbac:groups=reforma.prosimcorp.com,resources=patches,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=reforma.prosimcorp.com,resources=patches/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=reforma.prosimcorp.com,resources=patches/finalizers,verbs=update
+//+kubebuilder:rbac:groups=reforma.prosimcorp.com,resources=referencegrants,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups="",resources=secrets;configmaps,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -59,8 +95,22 @@ type PatchReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	return r.ReconcilePatchObject(ctx, req, &reformav1beta1.Patch{})
+}
+
+// ReconcilePatchObject perform the reconciliation for any kind implementing PatchObject.
+// It is shared by Patch and ClusterPatch, as both are synchronized the same way
+func (r *PatchReconciler) ReconcilePatchObject(ctx context.Context, req ctrl.Request, patchManifest reformav1beta1.PatchObject) (result ctrl.Result, err error) {
+	// Trace the whole reconciliation, recording the reason of its outcome
+	ctx, span := startSpan(ctx, req.NamespacedName, "Reconcile")
+	defer func() {
+		if condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched); condition != nil {
+			span.SetAttributes(attribute.String(spanAttributeReason, condition.Reason))
+		}
+		endSpan(span, err)
+	}()
+
 	//1. Get the content of the Patch
-	patchManifest := &reformav1beta1.Patch{}
 	err = r.Get(ctx, req.NamespacedName, patchManifest)
 
 	// 2. Check existence on the cluster
@@ -69,6 +119,7 @@ func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (resu
 		// 2.1 It does NOT exist: manage removal
 		if err = client.IgnoreNotFound(err); err == nil {
 			LogInfof(ctx, patchNotFoundError)
+			DeletePatchMetrics(req.NamespacedName)
 			return result, err
 		}
 
@@ -78,14 +129,32 @@ func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (resu
 	}
 
 	// 3. Check if the Patch instance is marked to be deleted: indicated by the deletion timestamp being set
-	if !patchManifest.DeletionTimestamp.IsZero() {
+	if !patchManifest.GetDeletionTimestamp().IsZero() {
 		if controllerutil.ContainsFinalizer(patchManifest, patchFinalizer) {
+
+			// Restore the targets before releasing the Patch CR, when requested.
+			// Fields owned by applied steps are always released
+			if len(getApplyFieldManagers(patchManifest)) > 0 {
+				err = r.ReleaseAppliedFields(ctx, patchManifest)
+			}
+			if err == nil {
+				err = r.RevertTargets(ctx, patchManifest)
+			}
+			if err != nil {
+				LogErrorf(ctx, err, patchRevertError, req.Name)
+				if statusErr := r.Status().Update(ctx, patchManifest); statusErr != nil {
+					LogInfof(ctx, patchConditionUpdateError, req.Name)
+				}
+				return result, err
+			}
+
 			// Remove the finalizers on Patch CR
 			controllerutil.RemoveFinalizer(patchManifest, patchFinalizer)
 			err = r.Update(ctx, patchManifest)
 			if err != nil {
 				LogInfof(ctx, patchFinalizersUpdateError, req.Name)
 			}
+			DeletePatchMetrics(req.NamespacedName)
 		}
 		result = ctrl.Result{}
 		err = nil
@@ -101,45 +170,96 @@ func (r *PatchReconciler) Reconcile(ctx context.Context, req ctrl.Request) (resu
 		}
 	}
 
-	// 5. Update the status before the requeue
+	// 5. Watch the sources and the target to react to their changes as soon as they happen
+	err = r.WatchReferencedKinds(ctx, patchManifest)
+	if err != nil {
+		LogErrorf(ctx, err, patchWatchError, patchManifest.GetName())
+	}
+
+	// 6. Update the status and the readiness metric before the requeue
 	defer func() {
+		r.RecordReadinessMetric(patchManifest)
 		err = r.Status().Update(ctx, patchManifest)
 		if err != nil {
 			LogInfof(ctx, patchConditionUpdateError, req.Name)
 		}
 	}()
 
-	// 6. Schedule periodical request
+	// 7. Schedule periodical request. It is a safety net, as changes on referenced objects are watched
 	RequeueTime, err := r.GetSynchronizationTime(patchManifest)
 	if err != nil {
-		LogInfof(ctx, patchSyncTimeRetrievalError, patchManifest.Name)
+		LogInfof(ctx, patchSyncTimeRetrievalError, patchManifest.GetName())
 		return result, err
 	}
 	result = ctrl.Result{
 		RequeueAfter: RequeueTime,
 	}
 
-	// 7. The Patch CR already exist: manage the update
+	// 8. The Patch CR already exist: manage the update.
+	// The previous condition is kept to emit events only when failures change
+	previousCondition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched).DeepCopy()
 	err = r.PatchTarget(ctx, patchManifest)
+	r.UpdateSyncStatus(patchManifest, err)
+	r.RecordSyncMetrics(patchManifest, err)
+	r.RecordPatchEvent(patchManifest, previousCondition, err)
 	if err != nil {
-		LogInfof(ctx, patchTargetError, patchManifest.Name)
+		LogInfof(ctx, patchTargetError, patchManifest.GetName())
 		return result, err
 	}
 
-	// 8. Success, update the status
-	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
-		metav1.ConditionTrue,
-		ConditionReasonTargetPatched,
-		ConditionReasonTargetPatchedMessage,
-	))
+	// 9. Success, update the status
+	if isPatchSkipped(patchManifest) {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionTrue,
+			ConditionReasonSkipped,
+			ConditionReasonSkippedMessage,
+		))
+	} else {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionTrue,
+			ConditionReasonTargetPatched,
+			ConditionReasonTargetPatchedMessage,
+		))
+	}
 
 	LogInfof(ctx, scheduleSynchronization, result.RequeueAfter.String())
 	return result, err
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *PatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&reformav1beta1.Patch{}).
-		Complete(r)
+func (r *PatchReconciler) SetupWithManager(mgr ctrl.Manager) (err error) {
+	err = r.SetupPatchObjectWithManager(mgr, r, &reformav1beta1.Patch{}, &reformav1beta1.PatchList{})
+	if err != nil {
+		return err
+	}
+
+	// ReferenceGrants change what namespaced Patches are permitted to reference
+	return r.controller.Watch(source.Kind(mgr.GetCache(), &reformav1beta1.ReferenceGrant{}),
+		handler.EnqueueRequestsFromMapFunc(r.enqueueGrantedPatches))
+}
+
+// SetupPatchObjectWithManager sets up a controller for any kind implementing PatchObject with the Manager
+func (r *PatchReconciler) SetupPatchObjectWithManager(mgr ctrl.Manager, reconciler reconcile.Reconciler,
+	patchObject reformav1beta1.PatchObject, patchListType client.ObjectList) (err error) {
+
+	// Index the Patches by the objects they reference to find them when those objects change
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), patchObject,
+		referencedObjectsIndexField, indexReferencedObjects)
+	if err != nil {
+		return err
+	}
+
+	// Status updates are ignored to avoid reconciling the Patch each time its conditions are written
+	r.controller, err = ctrl.NewControllerManagedBy(mgr).
+		For(patchObject, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Build(reconciler)
+	if err != nil {
+		return err
+	}
+
+	r.cache = mgr.GetCache()
+	r.restConfig = mgr.GetConfig()
+	r.patchListType = patchListType
+
+	return err
 }
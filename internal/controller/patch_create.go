@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// getTargetManifest return the object to create as target from the rendered template.
+// The ownerReference to the Patch is added when requested
+func (r *PatchReconciler) getTargetManifest(patchManifest reformav1beta1.PatchObject, target *unstructured.Unstructured,
+	manifest []byte) (targetManifest *unstructured.Unstructured, err error) {
+
+	manifest, err = completeTargetManifest(manifest, target)
+	if err != nil {
+		return targetManifest, err
+	}
+
+	targetManifest = &unstructured.Unstructured{}
+	err = json.Unmarshal(manifest, &targetManifest.Object)
+	if err != nil {
+		return targetManifest, err
+	}
+
+	// Owners must live in the same namespace of the object, or be cluster-scoped
+	if patchManifest.GetSpec().SetOwnerReference {
+		err = controllerutil.SetOwnerReference(patchManifest, targetManifest, r.Scheme)
+	}
+
+	return targetManifest, err
+}
+
+// CreateTarget create a missing target using the rendered template as its whole manifest, updating the target with it.
+// Created targets are recorded to be deleted when the Patch is deleted with the Revert policy
+func (r *PatchReconciler) CreateTarget(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject,
+	target *unstructured.Unstructured, manifest []byte) (err error) {
+
+	targetManifest, err := r.getTargetManifest(patchManifest, target, manifest)
+	if err != nil {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonInvalidPatch,
+			ConditionReasonInvalidPatchMessage,
+		))
+		return err
+	}
+
+	err = patchClient.Create(ctx, targetManifest, client.FieldOwner(GetFieldManager(patchManifest)))
+	if err != nil {
+		if r.updateForbiddenCondition(patchManifest, err) {
+			return err
+		}
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonTargetNotCreated,
+			ConditionReasonTargetNotCreatedMessage,
+		))
+		return err
+	}
+
+	// Following steps work on the created target
+	targetManifest.DeepCopyInto(target)
+
+	// Created targets are persisted right away, as they could not be deleted on revert otherwise.
+	// It is not done before creating them, as the target could be created by others in the meantime
+	if patchManifest.GetSpec().DeletionPolicy == reformav1beta1.DeletionPolicyRevert {
+		getTargetRevertStatus(patchManifest, targetManifest).Created = true
+
+		err = r.persistRevertStatus(ctx, patchManifest)
+		if err != nil {
+			r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+				metav1.ConditionFalse,
+				ConditionReasonRevertNotRecorded,
+				fmt.Sprintf(ConditionReasonRevertNotRecordedMessage, err.Error()),
+			))
+		}
+	}
+
+	return err
+}
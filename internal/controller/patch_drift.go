@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// targetSync is the result of synchronizing a target, recorded inside its status
+type targetSync struct {
+
+	// failedStep is the step that failed, when the Patch is defined by steps
+	failedStep string
+
+	// drifted is true when the fields patched on the previous synchronization were changed by others
+	drifted bool
+
+	// unchanged is true when the target was not patched, as the patches would not change it
+	unchanged bool
+
+	// uid and resourceVersion identify the target after synchronizing it, when it was found
+	uid             types.UID
+	resourceVersion string
+
+	// patchHash, appliedHash and appliedPaths are what was applied on the target, to detect when it drifts
+	patchHash    string
+	appliedHash  string
+	appliedPaths []string
+}
+
+// getLeafPaths fill the paths list with the JSON pointers to the leaves of an object patch.
+// Directives of strategic merge patches are not fields, so they are ignored. Empty maps, and the root
+// of patches rendering nothing, touch no field, so they are ignored too
+func getLeafPaths(value interface{}, prefix string, paths *[]string) {
+	valueMap, isMap := value.(map[string]interface{})
+	if !isMap {
+		if prefix != "" {
+			*paths = append(*paths, prefix)
+		}
+		return
+	}
+
+	for key, child := range valueMap {
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+		getLeafPaths(child, prefix+"/"+jsonPointerEscaper.Replace(key), paths)
+	}
+}
+
+// getPatchPaths return the JSON pointers to the fields touched by a patch.
+// The root of the object is never returned, as it would cover the fields changed by Kubernetes itself
+func getPatchPaths(patchType types.PatchType, patch []byte) (paths []string, err error) {
+
+	if patchType == types.JSONPatchType {
+		operations := []map[string]interface{}{}
+		err = json.Unmarshal(patch, &operations)
+		if err != nil {
+			return paths, err
+		}
+
+		for _, operation := range operations {
+			if operation["op"] == "test" {
+				continue
+			}
+			if path, ok := operation["path"].(string); ok && path != "" {
+				paths = append(paths, path)
+			}
+			if from, ok := operation["from"].(string); ok && from != "" {
+				paths = append(paths, from)
+			}
+		}
+		return paths, err
+	}
+
+	var patchObject interface{}
+	err = json.Unmarshal(patch, &patchObject)
+	if err != nil {
+		return paths, err
+	}
+
+	getLeafPaths(patchObject, "", &paths)
+	return paths, err
+}
+
+// getJSONPointerValue return the value of an object pointed by a JSON pointer, and whether it exists
+func getJSONPointerValue(object interface{}, pointer string) (value interface{}, found bool) {
+	value = object
+	if pointer == "" {
+		return value, true
+	}
+
+	for _, segment := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		segment = jsonPointerUnescaper.Replace(segment)
+
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			value, found = typedValue[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			found = err == nil && index >= 0 && index < len(typedValue)
+			if found {
+				value = typedValue[index]
+			}
+		default:
+			found = false
+		}
+
+		if !found {
+			return nil, found
+		}
+	}
+
+	return value, found
+}
+
+// getPathsHash return a hash of the values of an object at the given paths.
+// Missing values are hashed too, so removing a patched field is noticed
+func getPathsHash(object interface{}, paths []string) (hash string, err error) {
+	values := make([]interface{}, 0, len(paths))
+	for _, path := range paths {
+		value, found := getJSONPointerValue(object, path)
+		values = append(values, []interface{}{path, found, value})
+	}
+
+	rawValues, err := json.Marshal(values)
+	if err != nil {
+		return hash, err
+	}
+
+	sum := sha256.Sum256(rawValues)
+	return hex.EncodeToString(sum[:]), err
+}
+
+// getPatchesHash return a hash of the rendered patches of all the steps
+func getPatchesHash(patches [][]byte) string {
+	hasher := sha256.New()
+	for _, patch := range patches {
+		hasher.Write(patch)
+		hasher.Write([]byte{0})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// getUniquePaths return the paths sorted and without duplicates
+func getUniquePaths(paths []string) (uniquePaths []string) {
+	sort.Strings(paths)
+	for i, path := range paths {
+		if i == 0 || path != paths[i-1] {
+			uniquePaths = append(uniquePaths, path)
+		}
+	}
+	return uniquePaths
+}
+
+// isTargetDrifted return whether the fields patched on the previous synchronization of a target
+// were changed by others since then
+func isTargetDrifted(target map[string]interface{}, previous *reformav1beta1.TargetStatus) (drifted bool, err error) {
+	if previous == nil || previous.AppliedHash == "" {
+		return drifted, err
+	}
+
+	// Paths recorded by older versions may include the root, which changes on every update of the target
+	if slices.Contains(previous.AppliedPaths, "") {
+		return drifted, err
+	}
+
+	currentHash, err := getPathsHash(target, previous.AppliedPaths)
+	if err != nil {
+		return drifted, err
+	}
+
+	return currentHash != previous.AppliedHash, err
+}
+
+// isTargetUnchanged return whether patching a target would leave it unchanged. It happens when the patches are the
+// ones successfully applied on the previous synchronization, and the patched fields were not changed since then
+func isTargetUnchanged(previous *reformav1beta1.TargetStatus, drifted bool, patchHash string) bool {
+	if previous == nil || previous.Status != metav1.ConditionTrue || previous.AppliedHash == "" {
+		return false
+	}
+
+	return !drifted && previous.PatchHash == patchHash
+}
+
+// getPreviousTargetStatus return the status of a target from the previous synchronization, or nil when not existent
+func getPreviousTargetStatus(previousTargets []reformav1beta1.TargetStatus, patchManifest reformav1beta1.PatchObject,
+	target corev1.ObjectReference) *reformav1beta1.TargetStatus {
+
+	for i, previous := range previousTargets {
+		if previous.APIVersion == target.APIVersion && previous.Kind == target.Kind &&
+			previous.Namespace == getReferenceNamespace(patchManifest, target) && previous.Name == target.Name {
+			return &previousTargets[i]
+		}
+	}
+	return nil
+}
+
+// UpdateDriftStatus record inside the status of the CR whether the targets drifted since the previous synchronization
+func (r *PatchReconciler) UpdateDriftStatus(patchManifest reformav1beta1.PatchObject, driftedTargets int) {
+
+	if driftedTargets == 0 {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeDriftDetected,
+			metav1.ConditionFalse,
+			ConditionReasonNoDrift,
+			ConditionReasonNoDriftMessage,
+		))
+		return
+	}
+
+	now := metav1.Now()
+	patchManifest.GetStatus().DriftCount += int64(driftedTargets)
+	driftDetectionsTotal.WithLabelValues(getPatchMetricLabels(patchManifest)...).Add(float64(driftedTargets))
+	patchManifest.GetStatus().LastDriftTime = &now
+
+	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeDriftDetected,
+		metav1.ConditionTrue,
+		ConditionReasonTargetDrifted,
+		ConditionReasonTargetDriftedMessage,
+	))
+}
+
+// countTargetSync count inside the status of the CR whether a successfully synchronized target was patched or not
+func (r *PatchReconciler) countTargetSync(patchManifest reformav1beta1.PatchObject, sync targetSync) {
+	if sync.unchanged {
+		patchManifest.GetStatus().UnchangedSyncCount++
+		unchangedTargetsTotal.WithLabelValues(getPatchMetricLabels(patchManifest)...).Inc()
+		return
+	}
+	patchManifest.GetStatus().AppliedSyncCount++
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGetPatchPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		patchType types.PatchType
+		patch     string
+		want      []string
+	}{
+		{
+			name:      "merge patch leaves",
+			patchType: types.MergePatchType,
+			patch:     `{"metadata":{"annotations":{"a":"1","example.com/b":"2"}},"data":{"c":null}}`,
+			want:      []string{"/data/c", "/metadata/annotations/a", "/metadata/annotations/example.com~1b"},
+		},
+		{
+			name:      "lists are leaves",
+			patchType: types.StrategicMergePatchType,
+			patch:     `{"spec":{"containers":[{"name":"a"}]}}`,
+			want:      []string{"/spec/containers"},
+		},
+		{
+			name:      "strategic merge directives are ignored",
+			patchType: types.StrategicMergePatchType,
+			patch:     `{"metadata":{"$patch":"replace","labels":{"a":"1"}}}`,
+			want:      []string{"/metadata/labels/a"},
+		},
+		{
+			name:      "empty patch touches nothing",
+			patchType: types.MergePatchType,
+			patch:     `{}`,
+			want:      nil,
+		},
+		{
+			name:      "null patch touches nothing",
+			patchType: types.MergePatchType,
+			patch:     `null`,
+			want:      nil,
+		},
+		{
+			name:      "empty maps touch nothing",
+			patchType: types.MergePatchType,
+			patch:     `{"metadata":{"annotations":{}},"data":{"a":"1"}}`,
+			want:      []string{"/data/a"},
+		},
+		{
+			name:      "JSON patch paths",
+			patchType: types.JSONPatchType,
+			patch: `[{"op":"test","path":"/data/a","value":"1"},{"op":"add","path":"/data/b","value":"2"},` +
+				`{"op":"move","from":"/data/c","path":"/data/d"}]`,
+			want: []string{"/data/b", "/data/c", "/data/d"},
+		},
+		{
+			name:      "JSON patch replacing the root touches nothing",
+			patchType: types.JSONPatchType,
+			patch:     `[{"op":"replace","path":"","value":{}}]`,
+			want:      nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths, err := getPatchPaths(test.patchType, []byte(test.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sort.Strings(paths)
+			if !reflect.DeepEqual(paths, test.want) {
+				t.Errorf("got %v, want %v", paths, test.want)
+			}
+		})
+	}
+}
+
+func TestIsTargetDrifted(t *testing.T) {
+	patched := `{"metadata":{"resourceVersion":"1","annotations":{"a":"1"}},"status":{"ready":false}}`
+
+	tests := []struct {
+		name    string
+		patch   string
+		current string
+		want    bool
+	}{
+		{
+			name:    "patched fields unchanged",
+			patch:   `{"metadata":{"annotations":{"a":"1"}}}`,
+			current: `{"metadata":{"resourceVersion":"2","annotations":{"a":"1","b":"2"}},"status":{"ready":true}}`,
+			want:    false,
+		},
+		{
+			name:    "patched field changed",
+			patch:   `{"metadata":{"annotations":{"a":"1"}}}`,
+			current: `{"metadata":{"resourceVersion":"2","annotations":{"a":"other"}},"status":{"ready":false}}`,
+			want:    true,
+		},
+		{
+			name:    "patched field removed",
+			patch:   `{"metadata":{"annotations":{"a":"1"}}}`,
+			current: `{"metadata":{"resourceVersion":"2"},"status":{"ready":false}}`,
+			want:    true,
+		},
+		{
+			name:    "empty patch never drifts",
+			patch:   `{}`,
+			current: `{"metadata":{"resourceVersion":"2","annotations":{"a":"other"}},"status":{"ready":true}}`,
+			want:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths, err := getPatchPaths(types.MergePatchType, []byte(test.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			paths = getUniquePaths(paths)
+			appliedHash, err := getPathsHash(decodeObject(t, patched), paths)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			previous := &reformav1beta1.TargetStatus{
+				Status:       metav1.ConditionTrue,
+				AppliedHash:  appliedHash,
+				AppliedPaths: paths,
+			}
+
+			drifted, err := isTargetDrifted(decodeObject(t, test.current), previous)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if drifted != test.want {
+				t.Errorf("got drifted %v, want %v", drifted, test.want)
+			}
+		})
+	}
+}
+
+func TestIsTargetDriftedWithoutPreviousSync(t *testing.T) {
+	target := decodeObject(t, `{"metadata":{"annotations":{"a":"1"}}}`)
+
+	for _, previous := range []*reformav1beta1.TargetStatus{
+		nil,
+		{Status: metav1.ConditionFalse},
+		{Status: metav1.ConditionTrue, AppliedHash: "outdated", AppliedPaths: []string{""}},
+	} {
+		drifted, err := isTargetDrifted(target, previous)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if drifted {
+			t.Errorf("target drifted for previous status %+v", previous)
+		}
+	}
+}
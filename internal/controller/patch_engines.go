@@ -0,0 +1,478 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+	"prosimcorp.com/reforma/internal/sandbox"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"github.com/itchyny/gojq"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// jqTimeout is the maximum time a jq program can run, to avoid endless ones blocking the controller
+	jqTimeout = 5 * time.Second
+
+	// jqMaxEvaluations is the maximum number of jq programs running at the same time
+	jqMaxEvaluations = 4
+
+	// jqMaxMemoryBytes is the memory a jq program can reserve at most
+	jqMaxMemoryBytes = 256 << 20
+
+	// jqMaxOutputBytes is the size of the patch a jq program can produce at most
+	jqMaxOutputBytes = 1 << 20
+
+	// jqSandboxHandler is the name of the sandbox handler running jq programs
+	jqSandboxHandler = "jq"
+
+	// jqTimeoutError error message for jq programs not finishing in time
+	jqTimeoutError = "jq program did not finish in %s"
+
+	// jqMultipleOutputsError error message for jq programs emitting more than one patch
+	jqMultipleOutputsError = "jq program must emit a single patch, but emitted several"
+
+	// jqNoOutputError error message for jq programs emitting nothing
+	jqNoOutputError = "jq program must emit a single patch, but emitted nothing"
+
+	// jqParseError error message for jq programs that can not be parsed, pointing to where it failed
+	jqParseError = "line %d, column %d: %s"
+
+	// jsonnetTemplateFilename is the name given to Jsonnet programs, shown in their errors
+	jsonnetTemplateFilename = "template.jsonnet"
+
+	// jsonnetTimeout is the maximum time a Jsonnet program can run, to avoid endless ones blocking the controller
+	jsonnetTimeout = 5 * time.Second
+
+	// jsonnetMaxStack is the maximum depth of the calls of Jsonnet programs, lower than the default of the VM
+	jsonnetMaxStack = 200
+
+	// jsonnetMaxEvaluations is the maximum number of Jsonnet programs running at the same time
+	jsonnetMaxEvaluations = 4
+
+	// jsonnetMaxMemoryBytes is the memory a Jsonnet program can reserve at most
+	jsonnetMaxMemoryBytes = 256 << 20
+
+	// jsonnetMaxOutputBytes is the size of the patch a Jsonnet program can produce at most
+	jsonnetMaxOutputBytes = 1 << 20
+
+	// jsonnetSandboxHandler is the name of the sandbox handler evaluating Jsonnet programs
+	jsonnetSandboxHandler = "jsonnet"
+
+	// jsonnetTimeoutError error message for Jsonnet programs not finishing in time
+	jsonnetTimeoutError = "Jsonnet program did not finish in %s"
+
+	// jsonnetTailStrictError error message for Jsonnet programs using tailstrict calls. They are not counted
+	// in the stack limit, so endless recursions would crash the controller instead of failing
+	jsonnetTailStrictError = "%s:%s: tailstrict calls are not allowed"
+)
+
+var (
+	// templateEngineNames store the name of each engine shown in the conditions when rendering fails
+	templateEngineNames = map[reformav1beta1.TemplateEngine]string{
+		reformav1beta1.TemplateEngineTemplate: "Golang",
+		reformav1beta1.TemplateEngineCEL:      "CEL",
+		reformav1beta1.TemplateEngineJsonnet:  "Jsonnet",
+		reformav1beta1.TemplateEngineJq:       "jq",
+	}
+
+	// jsonnetEvaluations hold a slot for each Jsonnet program running
+	jsonnetEvaluations = make(chan struct{}, jsonnetMaxEvaluations)
+
+	// jqEvaluations hold a slot for each jq program running
+	jqEvaluations = make(chan struct{}, jqMaxEvaluations)
+)
+
+// jsonnetProgram is what the sandbox evaluating a Jsonnet program receives
+type jsonnetProgram struct {
+	Template  string            `json:"template"`
+	Libraries map[string]string `json:"libraries"`
+	Variables map[string]string `json:"variables"`
+}
+
+// jqProgram is what the sandbox running a jq program receives
+type jqProgram struct {
+	Template string          `json:"template"`
+	Input    json.RawMessage `json:"input"`
+}
+
+func init() {
+	sandbox.Register(jsonnetSandboxHandler, evaluateJsonnetProgram)
+	sandbox.Register(jqSandboxHandler, runJqProgram)
+}
+
+// RenderError is returned by the engines when a template can not be rendered.
+// Parsing tells whether it failed parsing the template, or executing it
+type RenderError struct {
+	Parsing bool
+	Err     error
+}
+
+// Error return the message of the error returned by the engine
+func (e *RenderError) Error() string {
+	return e.Err.Error()
+}
+
+// getTemplateEngine return the engine rendering the templates of a Patch. Go templates are used when empty
+func getTemplateEngine(patchManifest reformav1beta1.PatchObject) reformav1beta1.TemplateEngine {
+	if patchManifest.GetSpec().Engine == "" {
+		return reformav1beta1.TemplateEngineTemplate
+	}
+	return patchManifest.GetSpec().Engine
+}
+
+// parseGoTemplate parse a Go template, mapping useful sprig functions to give superpower to the users
+func (r *PatchReconciler) parseGoTemplate(text string) (parsedTemplate *template.Template, err error) {
+	return template.New("main").Funcs(r.GetFunctionsMap()).Parse(text)
+}
+
+// compileJq compile a jq program. Parsing errors point to the line and column where they happened.
+// Environment variables are not given to the program, so it can not read the ones of the controller
+func compileJq(program string) (code *gojq.Code, err error) {
+	query, err := gojq.Parse(program)
+	if err != nil {
+		parseErr := &gojq.ParseError{}
+		if errors.As(err, &parseErr) {
+			consumed := program[:min(parseErr.Offset, len(program))]
+			line := strings.Count(consumed, "\n") + 1
+			column := len(consumed) - strings.LastIndex(consumed, "\n")
+			err = NewErrorf(jqParseError, line, column, err.Error())
+		}
+		return code, err
+	}
+
+	return gojq.Compile(query)
+}
+
+// parseJsonnet parse a Jsonnet program or library, rejecting the tailstrict calls
+func parseJsonnet(filename string, content string) (node ast.Node, err error) {
+	node, err = jsonnet.SnippetToAST(filename, content)
+	if err != nil {
+		return node, err
+	}
+
+	pending := []ast.Node{node}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if apply, ok := current.(*ast.Apply); ok && apply.TailStrict {
+			return node, NewErrorf(jsonnetTailStrictError, filename, apply.Loc().Begin.String())
+		}
+		pending = append(pending, toolutils.Children(current)...)
+	}
+
+	return node, err
+}
+
+// parseJsonnetLibraries parse the Jsonnet libraries of a Patch, returning them ready to be imported
+func parseJsonnetLibraries(patchManifest reformav1beta1.PatchObject) (libraries map[string]jsonnet.Contents, err error) {
+	libraries = map[string]jsonnet.Contents{}
+	for path, content := range patchManifest.GetSpec().JsonnetLibraries {
+		_, err = parseJsonnet(path, content)
+		if err != nil {
+			return libraries, err
+		}
+		libraries[path] = jsonnet.MakeContents(content)
+	}
+	return libraries, err
+}
+
+// ParseTemplate parse the template of a step with the engine of the Patch, without rendering it
+func (r *PatchReconciler) ParseTemplate(patchManifest reformav1beta1.PatchObject, step reformav1beta1.PatchStepSpec) (err error) {
+	switch getTemplateEngine(patchManifest) {
+	case reformav1beta1.TemplateEngineCEL:
+		_, _, err = compileCELExpression(step.Template)
+	case reformav1beta1.TemplateEngineJsonnet:
+		_, err = parseJsonnet(jsonnetTemplateFilename, step.Template)
+		if err == nil {
+			_, err = parseJsonnetLibraries(patchManifest)
+		}
+	case reformav1beta1.TemplateEngineJq:
+		_, err = compileJq(step.Template)
+	default:
+		_, err = r.parseGoTemplate(step.Template)
+	}
+	return err
+}
+
+// renderGoTemplate render a patch from a Go template with Sprig functions
+func (r *PatchReconciler) renderGoTemplate(patchManifest reformav1beta1.PatchObject, step reformav1beta1.PatchStepSpec,
+	resources []interface{}) (patch string, err error) {
+
+	template, err := r.parseGoTemplate(step.Template)
+	if err != nil {
+		return patch, &RenderError{Parsing: true, Err: err}
+	}
+
+	// Shape the resources as the template expects them
+	templateContext, err := r.GetTemplateContext(patchManifest, resources)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	// Create a new buffer to store the templating result
+	buffer := new(bytes.Buffer)
+
+	err = template.Execute(buffer, templateContext)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	return buffer.String(), err
+}
+
+// renderCEL render a patch from a CEL expression returning it as an object, or a list of operations
+func (r *PatchReconciler) renderCEL(patchManifest reformav1beta1.PatchObject, step reformav1beta1.PatchStepSpec,
+	resources []interface{}) (patch string, err error) {
+
+	program, err := getCELProgram(step.Template)
+	if err != nil {
+		return patch, &RenderError{Parsing: true, Err: err}
+	}
+
+	variables, err := r.getEngineVariables(patchManifest, resources)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	result, err := evaluateCELProgram(program, variables)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	// Convert the CEL value into JSON, which is valid YAML too
+	nativeResult, err := result.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	patchBytes, err := json.Marshal(nativeResult.(*structpb.Value).AsInterface())
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	return string(patchBytes), err
+}
+
+// getEngineTimeout return the time a program can run, which is shorter than the timeout of its engine
+// when the context finishes before, as it happens on admission
+func getEngineTimeout(ctx context.Context, engineTimeout time.Duration) (timeout time.Duration) {
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline || time.Until(deadline) >= engineTimeout {
+		return engineTimeout
+	}
+	return time.Until(deadline).Round(100 * time.Millisecond)
+}
+
+// evaluateJsonnetProgram evaluate a Jsonnet program inside a sandbox, returning the resulting JSON
+func evaluateJsonnetProgram(input []byte) (output []byte, err error) {
+	program := jsonnetProgram{}
+	err = json.Unmarshal(input, &program)
+	if err != nil {
+		return output, err
+	}
+
+	node, err := parseJsonnet(jsonnetTemplateFilename, program.Template)
+	if err != nil {
+		return output, err
+	}
+
+	libraries := map[string]jsonnet.Contents{}
+	for path, content := range program.Libraries {
+		libraries[path] = jsonnet.MakeContents(content)
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.MaxStack = jsonnetMaxStack
+	vm.Importer(&jsonnet.MemoryImporter{Data: libraries})
+
+	for name, value := range program.Variables {
+		vm.ExtCode(name, value)
+	}
+
+	result, err := vm.Evaluate(node)
+	return []byte(result), err
+}
+
+// evaluateJsonnet evaluate a Jsonnet program in a sandbox process, killing it when it does not finish in time
+// or uses too much memory. The limited slots bound how many processes are running at the same time
+func evaluateJsonnet(ctx context.Context, program jsonnetProgram) (result string, err error) {
+	timeout := getEngineTimeout(ctx, jsonnetTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case jsonnetEvaluations <- struct{}{}:
+		defer func() { <-jsonnetEvaluations }()
+	case <-ctx.Done():
+		return result, NewErrorf(jsonnetTimeoutError, timeout)
+	}
+
+	input, err := json.Marshal(program)
+	if err != nil {
+		return result, err
+	}
+
+	output, err := sandbox.Run(ctx, jsonnetSandboxHandler, input, sandbox.Limits{
+		Timeout:        timeout,
+		MaxMemoryBytes: jsonnetMaxMemoryBytes,
+		MaxOutputBytes: jsonnetMaxOutputBytes,
+	})
+	if errors.Is(err, sandbox.ErrTimeout) {
+		return result, NewErrorf(jsonnetTimeoutError, timeout)
+	}
+
+	return string(output), err
+}
+
+// renderJsonnet render a patch from a Jsonnet program. The data is given as external variables,
+// so they are available using std.extVar('target'), std.extVar('sources'), and so on
+func (r *PatchReconciler) renderJsonnet(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	step reformav1beta1.PatchStepSpec, resources []interface{}) (patch string, err error) {
+
+	_, err = parseJsonnet(jsonnetTemplateFilename, step.Template)
+	if err != nil {
+		return patch, &RenderError{Parsing: true, Err: err}
+	}
+
+	// Imports are resolved from the libraries of the Patch only, so programs can not read the filesystem of the controller
+	_, err = parseJsonnetLibraries(patchManifest)
+	if err != nil {
+		return patch, &RenderError{Parsing: true, Err: err}
+	}
+
+	variables, err := r.getEngineVariables(patchManifest, resources)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	program := jsonnetProgram{
+		Template:  step.Template,
+		Libraries: patchManifest.GetSpec().JsonnetLibraries,
+		Variables: map[string]string{},
+	}
+
+	for name, value := range variables {
+		rawValue, err := json.Marshal(value)
+		if err != nil {
+			return patch, &RenderError{Err: err}
+		}
+		program.Variables[name] = string(rawValue)
+	}
+
+	// The result is JSON, which is valid YAML too
+	patch, err = evaluateJsonnet(ctx, program)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	return patch, err
+}
+
+// runJqProgram run a jq program inside a sandbox, returning the only patch it emits as JSON
+func runJqProgram(input []byte) (output []byte, err error) {
+	program := jqProgram{}
+	err = json.Unmarshal(input, &program)
+	if err != nil {
+		return output, err
+	}
+
+	code, err := compileJq(program.Template)
+	if err != nil {
+		return output, err
+	}
+
+	// jq only understands the types produced by decoding JSON
+	var programInput interface{}
+	err = json.Unmarshal(program.Input, &programInput)
+	if err != nil {
+		return output, err
+	}
+
+	iterator := code.Run(programInput)
+
+	result, ok := iterator.Next()
+	if !ok {
+		return output, NewErrorf(jqNoOutputError)
+	}
+	if resultErr, isError := result.(error); isError {
+		return output, resultErr
+	}
+
+	if _, ok = iterator.Next(); ok {
+		return output, NewErrorf(jqMultipleOutputsError)
+	}
+
+	return json.Marshal(result)
+}
+
+// runJq run a jq program in a sandbox process, killing it when it does not finish in time
+// or uses too much memory. The limited slots bound how many processes are running at the same time
+func runJq(ctx context.Context, program jqProgram) (result string, err error) {
+	timeout := getEngineTimeout(ctx, jqTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case jqEvaluations <- struct{}{}:
+		defer func() { <-jqEvaluations }()
+	case <-ctx.Done():
+		return result, NewErrorf(jqTimeoutError, timeout)
+	}
+
+	input, err := json.Marshal(program)
+	if err != nil {
+		return result, err
+	}
+
+	output, err := sandbox.Run(ctx, jqSandboxHandler, input, sandbox.Limits{
+		Timeout:        timeout,
+		MaxMemoryBytes: jqMaxMemoryBytes,
+		MaxOutputBytes: jqMaxOutputBytes,
+	})
+	if errors.Is(err, sandbox.ErrTimeout) {
+		return result, NewErrorf(jqTimeoutError, timeout)
+	}
+
+	return string(output), err
+}
+
+// renderJq render a patch from a jq program. The data is given as the input of the program,
+// so it is available using .target, .sources, and so on
+func (r *PatchReconciler) renderJq(ctx context.Context, patchManifest reformav1beta1.PatchObject, step reformav1beta1.PatchStepSpec,
+	resources []interface{}) (patch string, err error) {
+
+	_, err = compileJq(step.Template)
+	if err != nil {
+		return patch, &RenderError{Parsing: true, Err: err}
+	}
+
+	variables, err := r.getEngineVariables(patchManifest, resources)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	input, err := json.Marshal(variables)
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	// The result is JSON, which is valid YAML too
+	patch, err = runJq(ctx, jqProgram{Template: step.Template, Input: input})
+	if err != nil {
+		return patch, &RenderError{Err: err}
+	}
+
+	return patch, err
+}
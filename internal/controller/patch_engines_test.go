@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+	"prosimcorp.com/reforma/internal/sandbox"
+)
+
+func TestMain(m *testing.M) {
+	// The engines running in sandboxes start copies of the test binary
+	sandbox.Init()
+	os.Exit(m.Run())
+}
+
+// newEngineTestResources return the resources given to the engines, being the target a ConfigMap with the given items
+func newEngineTestResources(items int) []interface{} {
+	values := make([]interface{}, 0, items)
+	for i := 0; i < items; i++ {
+		values = append(values, int64(i))
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "target"},
+			"items":    values,
+		},
+	}
+}
+
+// assertRenderError check that an engine rendered the wanted patch, or failed with the wanted error
+func assertRenderError(t *testing.T, patch string, err error, wantPatch string, wantParsing bool, wantErr string) {
+	t.Helper()
+
+	if wantErr == "" {
+		if err != nil {
+			t.Fatalf("got error %v, want none", err)
+		}
+		if patch != wantPatch {
+			t.Errorf("got patch %s, want %s", patch, wantPatch)
+		}
+		return
+	}
+
+	renderErr := &RenderError{}
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("got error %v, want a render error", err)
+	}
+	if renderErr.Parsing != wantParsing {
+		t.Errorf("got parsing %t, want %t", renderErr.Parsing, wantParsing)
+	}
+	if !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("got error %q, want it to contain %q", err, wantErr)
+	}
+}
+
+func TestGetEngineTimeout(t *testing.T) {
+	shortCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	longCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want time.Duration
+	}{
+		{
+			name: "context without deadline",
+			ctx:  context.Background(),
+			want: 5 * time.Second,
+		},
+		{
+			name: "context finishing before the engine timeout",
+			ctx:  shortCtx,
+			want: 2 * time.Second,
+		},
+		{
+			name: "context finishing after the engine timeout",
+			ctx:  longCtx,
+			want: 5 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if timeout := getEngineTimeout(test.ctx, 5*time.Second); timeout != test.want {
+				t.Errorf("got %s, want %s", timeout, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderCEL(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		items       int
+		wantPatch   string
+		wantParsing bool
+		wantErr     string
+	}{
+		{
+			name:      "object built from the target",
+			template:  `{"data": {"name": target.metadata.name, "items": string(target.items.size())}}`,
+			items:     3,
+			wantPatch: `{"data":{"items":"3","name":"target"}}`,
+		},
+		{
+			name:        "expression not compiling",
+			template:    `{"data": target.metadata.name +}`,
+			wantParsing: true,
+			wantErr:     "Syntax error",
+		},
+		{
+			name:     "type error",
+			template: `{"data": target.metadata.name + 1}`,
+			wantErr:  "no such overload",
+		},
+		{
+			name:     "cost over the limit",
+			template: `{"data": target.items.map(a, target.items.map(b, a * b).size())}`,
+			items:    2000,
+			wantErr:  "cost limit exceeded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch, err := (&PatchReconciler{}).renderCEL(newValidPatch(),
+				reformav1beta1.PatchStepSpec{Template: test.template}, newEngineTestResources(test.items))
+
+			assertRenderError(t, patch, err, test.wantPatch, test.wantParsing, test.wantErr)
+		})
+	}
+}
+
+func TestGetCELProgramIsCached(t *testing.T) {
+	expression := `target.metadata.name == "cached"`
+
+	first, err := getCELProgram(expression)
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+	cachedPrograms := celPrograms.Len()
+
+	second, err := getCELProgram(expression)
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	if first != second {
+		t.Errorf("got a new program, want the cached one")
+	}
+	if celPrograms.Len() != cachedPrograms {
+		t.Errorf("got %d cached programs, want %d", celPrograms.Len(), cachedPrograms)
+	}
+}
+
+func TestRenderJsonnet(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		libraries   map[string]string
+		timeout     time.Duration
+		wantPatch   string
+		wantParsing bool
+		wantErr     string
+	}{
+		{
+			name:      "object built from the target",
+			template:  `{data: {name: std.extVar('target').metadata.name}}`,
+			wantPatch: `{"data":{"name":"target"}}`,
+		},
+		{
+			name:      "imported library",
+			template:  `local lib = import 'lib.libsonnet'; {data: lib.data}`,
+			libraries: map[string]string{"lib.libsonnet": `{data: {key: 'value'}}`},
+			wantPatch: `{"data":{"key":"value"}}`,
+		},
+		{
+			name:        "tailstrict calls",
+			template:    `local f(n) = if n == 0 then {} else f(n - 1) tailstrict; f(10)`,
+			wantParsing: true,
+			wantErr:     "tailstrict calls are not allowed",
+		},
+		{
+			name:     "stack over the limit",
+			template: `local f(n) = if n == 0 then 0 else 1 + f(n - 1); {data: f(10000)}`,
+			wantErr:  "max stack frames exceeded",
+		},
+		{
+			name:     "memory over the limit",
+			template: `{data: std.length(std.range(1, 1e9))}`,
+			wantErr:  "out of memory",
+		},
+		{
+			name:     "endless program",
+			template: `{data: std.foldl(function(total, i) total + std.length(std.range(1, 1e3)), std.range(1, 1e5), 0)}`,
+			timeout:  500 * time.Millisecond,
+			wantErr:  "did not finish in 500ms",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			patchManifest := newValidPatch()
+			patchManifest.Spec.JsonnetLibraries = test.libraries
+
+			patch, err := (&PatchReconciler{}).renderJsonnet(ctx, patchManifest,
+				reformav1beta1.PatchStepSpec{Template: test.template}, newEngineTestResources(0))
+
+			// Jsonnet indents its output
+			compactPatch := &bytes.Buffer{}
+			_ = json.Compact(compactPatch, []byte(patch))
+
+			assertRenderError(t, compactPatch.String(), err, test.wantPatch, test.wantParsing, test.wantErr)
+		})
+	}
+}
+
+func TestRenderJq(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		timeout     time.Duration
+		wantPatch   string
+		wantParsing bool
+		wantErr     string
+	}{
+		{
+			name:      "object built from the target",
+			template:  `{data: {name: .target.metadata.name}}`,
+			wantPatch: `{"data":{"name":"target"}}`,
+		},
+		{
+			name:        "program not compiling",
+			template:    `{data: .target.metadata.name`,
+			wantParsing: true,
+			wantErr:     "line 1, column",
+		},
+		{
+			name:     "no patch emitted",
+			template: `empty`,
+			wantErr:  jqNoOutputError,
+		},
+		{
+			name:     "several patches emitted",
+			template: `{}, {}`,
+			wantErr:  jqMultipleOutputsError,
+		},
+		{
+			name:      "environment variables",
+			template:  `{data: $ENV}`,
+			wantPatch: `{"data":{}}`,
+		},
+		{
+			name:     "memory over the limit",
+			template: `{data: [range(1e8)]}`,
+			wantErr:  "out of memory",
+		},
+		{
+			name:     "output over the limit",
+			template: `{data: [range(1e6)]}`,
+			wantErr:  "output is larger than",
+		},
+		{
+			name:     "endless program",
+			template: `{data: last(range(1e12))}`,
+			timeout:  500 * time.Millisecond,
+			wantErr:  "did not finish in 500ms",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			patch, err := (&PatchReconciler{}).renderJq(ctx, newValidPatch(),
+				reformav1beta1.PatchStepSpec{Template: test.template}, newEngineTestResources(0))
+
+			assertRenderError(t, patch, err, test.wantPatch, test.wantParsing, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"fmt"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// targetEventMessage is the message of the events emitted on the Patch about one of its targets
+	targetEventMessage = "%s %s: %s"
+)
+
+// getEventType return the type of the events about an outcome with the given status
+func getEventType(status metav1.ConditionStatus) string {
+	if status == metav1.ConditionTrue {
+		return corev1.EventTypeNormal
+	}
+	return corev1.EventTypeWarning
+}
+
+// getTargetEventObject return an object representing a target, to emit events on it
+func getTargetEventObject(targetStatus reformav1beta1.TargetStatus, uid types.UID) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: targetStatus.APIVersion,
+			Kind:       targetStatus.Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       targetStatus.Namespace,
+			Name:            targetStatus.Name,
+			UID:             uid,
+			ResourceVersion: targetStatus.ResourceVersion,
+		},
+	}
+}
+
+// RecordTargetEvent emit an event about the synchronization of a target on the Patch, and on the target when requested.
+// Targets actually patched, and drifted ones, are always reported. Other outcomes are only reported when they changed
+// since the previous synchronization, so Patches failing on each synchronization do not flood the events
+func (r *PatchReconciler) RecordTargetEvent(patchManifest reformav1beta1.PatchObject, previous *reformav1beta1.TargetStatus,
+	targetStatus reformav1beta1.TargetStatus, uid types.UID) {
+
+	if r.Recorder == nil {
+		return
+	}
+
+	if targetStatus.Drifted {
+		r.emitTargetEvent(patchManifest, targetStatus, uid, corev1.EventTypeWarning,
+			ConditionReasonTargetDrifted, ConditionReasonTargetDriftedMessage)
+	}
+
+	// Nothing happened to unchanged targets
+	if targetStatus.Reason == ConditionReasonTargetUnchanged {
+		return
+	}
+
+	if targetStatus.Reason != ConditionReasonTargetPatched && previous != nil &&
+		previous.Reason == targetStatus.Reason && previous.Message == targetStatus.Message {
+		return
+	}
+
+	r.emitTargetEvent(patchManifest, targetStatus, uid, getEventType(targetStatus.Status),
+		targetStatus.Reason, targetStatus.Message)
+}
+
+// emitTargetEvent emit an event about a target on the Patch, and on the target when requested and possible
+func (r *PatchReconciler) emitTargetEvent(patchManifest reformav1beta1.PatchObject, targetStatus reformav1beta1.TargetStatus,
+	uid types.UID, eventType, reason, message string) {
+
+	targetName := targetStatus.Name
+	if targetStatus.Namespace != "" {
+		targetName = targetStatus.Namespace + "/" + targetStatus.Name
+	}
+
+	r.Recorder.Event(patchManifest, eventType, reason, fmt.Sprintf(targetEventMessage, targetStatus.Kind, targetName, message))
+
+	// Events can only be bound to targets that were found
+	if patchManifest.GetSpec().TargetEvents && uid != "" {
+		r.Recorder.Event(getTargetEventObject(targetStatus, uid), eventType, reason, message)
+	}
+}
+
+// RecordPatchEvent emit an event on the Patch when its synchronization failed before reaching any target,
+// only when the failure changed since the previous synchronization
+func (r *PatchReconciler) RecordPatchEvent(patchManifest reformav1beta1.PatchObject, previousCondition *metav1.Condition, err error) {
+
+	if r.Recorder == nil || err == nil || len(patchManifest.GetStatus().Targets) > 0 {
+		return
+	}
+
+	condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched)
+	if condition == nil {
+		return
+	}
+
+	if previousCondition != nil && previousCondition.Reason == condition.Reason && previousCondition.Message == condition.Message {
+		return
+	}
+
+	r.Recorder.Event(patchManifest, corev1.EventTypeWarning, condition.Reason, condition.Message)
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"fmt"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// serviceAccountUsernameFormat is the username Kubernetes gives to ServiceAccounts
+	serviceAccountUsernameFormat = "system:serviceaccount:%s:%s"
+
+	// serviceAccountsGroup and serviceAccountsNamespaceGroupFormat are the groups Kubernetes gives to ServiceAccounts
+	serviceAccountsGroup                = "system:serviceaccounts"
+	serviceAccountsNamespaceGroupFormat = "system:serviceaccounts:%s"
+
+	// serviceAccountNamespaceMissingError error message for ClusterPatches impersonating a ServiceAccount without namespace
+	serviceAccountNamespaceMissingError = "ServiceAccount namespace is required to impersonate %s from the ClusterPatch: %s"
+)
+
+// getServiceAccountNamespace return the namespace of the ServiceAccount to impersonate.
+// Namespaced Patches always use ServiceAccounts from their own namespace
+func getServiceAccountNamespace(patchManifest reformav1beta1.PatchObject) string {
+	if patchManifest.GetNamespace() != "" {
+		return patchManifest.GetNamespace()
+	}
+	return patchManifest.GetSpec().ServiceAccountNamespace
+}
+
+// GetPatchClient return the client used to get the sources and patch the target of a Patch.
+// When a ServiceAccount is defined, the client impersonates it, so the Patch is limited to its permissions
+func (r *PatchReconciler) GetPatchClient(patchManifest reformav1beta1.PatchObject) (patchClient client.Client, err error) {
+
+	serviceAccountName := patchManifest.GetSpec().ServiceAccountName
+	if serviceAccountName == "" {
+		return r.Client, err
+	}
+
+	serviceAccountNamespace := getServiceAccountNamespace(patchManifest)
+	if serviceAccountNamespace == "" {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonInvalidServiceAccount,
+			ConditionReasonInvalidServiceAccountMessage,
+		))
+		err = NewErrorf(serviceAccountNamespaceMissingError, serviceAccountName, patchManifest.GetName())
+		return patchClient, err
+	}
+
+	config := rest.CopyConfig(r.restConfig)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: fmt.Sprintf(serviceAccountUsernameFormat, serviceAccountNamespace, serviceAccountName),
+	}
+
+	patchClient, err = client.New(config, client.Options{
+		Scheme: r.Scheme,
+		Mapper: r.RESTMapper(),
+	})
+
+	return patchClient, err
+}
+
+// updateForbiddenCondition set the Forbidden reason on the Patch when the error comes from a lack of permissions.
+// It returns whether the condition was updated
+func (r *PatchReconciler) updateForbiddenCondition(patchManifest reformav1beta1.PatchObject, err error) bool {
+	if !apierrors.IsForbidden(err) {
+		return false
+	}
+
+	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+		metav1.ConditionFalse,
+		ConditionReasonForbidden,
+		fmt.Sprintf(ConditionReasonForbiddenMessage, err.Error()),
+	))
+	return true
+}
@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"time"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "reforma"
+
+	metricsLabelNamespace = "namespace"
+	metricsLabelName      = "name"
+	metricsLabelReason    = "reason"
+	metricsLabelEngine    = "engine"
+	metricsLabelPatchType = "patch_type"
+)
+
+var (
+	// patchLabels are the labels identifying the Patch of every metric. ClusterPatches have an empty namespace
+	patchLabels = []string{metricsLabelNamespace, metricsLabelName}
+
+	syncAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_sync_attempts_total",
+		Help:      "Number of synchronizations attempted for each Patch",
+	}, patchLabels)
+
+	syncSuccessesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_sync_successes_total",
+		Help:      "Number of successful synchronizations for each Patch",
+	}, patchLabels)
+
+	syncFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_sync_failures_total",
+		Help:      "Number of failed synchronizations for each Patch, by the reason of its ResourcePatched condition",
+	}, append(patchLabels, metricsLabelReason))
+
+	templateRenderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_template_render_duration_seconds",
+		Help:      "Time spent rendering the patches of each Patch, by templating engine",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 8),
+	}, append(patchLabels, metricsLabelEngine))
+
+	apiPatchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_api_request_duration_seconds",
+		Help:      "Latency of the requests patching the targets of each Patch, by patch type",
+		Buckets:   prometheus.DefBuckets,
+	}, append(patchLabels, metricsLabelPatchType))
+
+	driftDetectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_drift_detections_total",
+		Help:      "Number of targets found drifted for each Patch",
+	}, patchLabels)
+
+	unchangedTargetsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_unchanged_targets_total",
+		Help:      "Number of targets not patched for each Patch, as the patches would not change them",
+	}, patchLabels)
+
+	patchNotReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "patch_not_ready",
+		Help:      "Whether each Patch is currently not Ready, being 1 when its ResourcePatched condition is not True",
+	}, patchLabels)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		syncAttemptsTotal,
+		syncSuccessesTotal,
+		syncFailuresTotal,
+		templateRenderDuration,
+		apiPatchDuration,
+		driftDetectionsTotal,
+		unchangedTargetsTotal,
+		patchNotReady,
+	)
+}
+
+// getPatchMetricLabels return the values of the labels identifying a Patch in the metrics
+func getPatchMetricLabels(patchManifest reformav1beta1.PatchObject, extraValues ...string) []string {
+	return append([]string{patchManifest.GetNamespace(), patchManifest.GetName()}, extraValues...)
+}
+
+// observeDuration record the time spent since the given start time in a histogram
+func observeDuration(histogram *prometheus.HistogramVec, start time.Time, labelValues ...string) {
+	histogram.WithLabelValues(labelValues...).Observe(time.Since(start).Seconds())
+}
+
+// RecordSyncMetrics count a synchronization of a Patch, and its failure reason when it failed
+func (r *PatchReconciler) RecordSyncMetrics(patchManifest reformav1beta1.PatchObject, err error) {
+
+	syncAttemptsTotal.WithLabelValues(getPatchMetricLabels(patchManifest)...).Inc()
+
+	if err == nil {
+		syncSuccessesTotal.WithLabelValues(getPatchMetricLabels(patchManifest)...).Inc()
+		return
+	}
+
+	reason := ConditionReasonInvalidPatch
+	condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched)
+	if condition != nil && condition.Status == metav1.ConditionFalse {
+		reason = condition.Reason
+	}
+	syncFailuresTotal.WithLabelValues(getPatchMetricLabels(patchManifest, reason)...).Inc()
+}
+
+// RecordReadinessMetric record whether a Patch is currently not Ready
+func (r *PatchReconciler) RecordReadinessMetric(patchManifest reformav1beta1.PatchObject) {
+
+	notReady := 1.0
+	condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched)
+	if condition != nil && condition.Status == metav1.ConditionTrue {
+		notReady = 0
+	}
+	patchNotReady.WithLabelValues(getPatchMetricLabels(patchManifest)...).Set(notReady)
+}
+
+// DeletePatchMetrics remove the metrics of a deleted Patch, so they are not exported forever
+func DeletePatchMetrics(patchKey types.NamespacedName) {
+	patchMetricLabels := prometheus.Labels{
+		metricsLabelNamespace: patchKey.Namespace,
+		metricsLabelName:      patchKey.Name,
+	}
+
+	syncAttemptsTotal.Delete(patchMetricLabels)
+	syncSuccessesTotal.Delete(patchMetricLabels)
+	syncFailuresTotal.DeletePartialMatch(patchMetricLabels)
+	templateRenderDuration.DeletePartialMatch(patchMetricLabels)
+	apiPatchDuration.DeletePartialMatch(patchMetricLabels)
+	driftDetectionsTotal.Delete(patchMetricLabels)
+	unchangedTargetsTotal.Delete(patchMetricLabels)
+	patchNotReady.Delete(patchMetricLabels)
+}
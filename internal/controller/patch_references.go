@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"context"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// referenceNotPermittedError error message for namespaced Patches referencing objects not granted to them
+	referenceNotPermittedError = "Patch %s is not permitted to reference %s %s/%s as %s"
+
+	grantedPatchesListError = "Can not list the Patches granted by the ReferenceGrant: %s"
+)
+
+// getReferenceNamespace return the namespace of a referenced object.
+// References without namespace inside namespaced Patches point to the namespace of the Patch
+func getReferenceNamespace(patchManifest reformav1beta1.PatchObject, reference corev1.ObjectReference) string {
+	if reference.Namespace == "" {
+		return patchManifest.GetNamespace()
+	}
+	return reference.Namespace
+}
+
+// getReferenceKey return the key to get a referenced object from the cluster
+func getReferenceKey(patchManifest reformav1beta1.PatchObject, reference corev1.ObjectReference) client.ObjectKey {
+	return client.ObjectKey{
+		Namespace: getReferenceNamespace(patchManifest, reference),
+		Name:      reference.Name,
+	}
+}
+
+// isClusterScoped return whether the kind of the reference is cluster-scoped
+func (r *PatchReconciler) isClusterScoped(reference corev1.ObjectReference) (clusterScoped bool, err error) {
+	gvk := reference.GroupVersionKind()
+
+	mapping, err := r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return clusterScoped, err
+	}
+
+	clusterScoped = mapping.Scope.Name() == meta.RESTScopeNameRoot
+	return clusterScoped, err
+}
+
+// isReferenceGranted return whether a ReferenceGrant in the namespace of the referenced object
+// allows the Patch to use it in the given way
+func (r *PatchReconciler) isReferenceGranted(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	reference corev1.ObjectReference, usage reformav1beta1.ReferenceUsage) (granted bool, err error) {
+
+	grantList := &reformav1beta1.ReferenceGrantList{}
+	err = r.List(ctx, grantList, client.InNamespace(getReferenceNamespace(patchManifest, reference)))
+	if err != nil {
+		return granted, err
+	}
+
+	gvk := reference.GroupVersionKind()
+
+	for _, grant := range grantList.Items {
+		fromAllowed := false
+		for _, from := range grant.Spec.From {
+			if from.Namespace == patchManifest.GetNamespace() {
+				fromAllowed = true
+				break
+			}
+		}
+		if !fromAllowed {
+			continue
+		}
+
+		for _, to := range grant.Spec.To {
+			if to.Group != gvk.Group || to.Kind != gvk.Kind {
+				continue
+			}
+			if to.Name != "" && to.Name != reference.Name {
+				continue
+			}
+			if to.Usage != "" && to.Usage != usage {
+				continue
+			}
+			return true, err
+		}
+	}
+
+	return granted, err
+}
+
+// isReferencePermitted return whether a namespaced Patch is allowed to use a referenced object
+func (r *PatchReconciler) isReferencePermitted(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	reference corev1.ObjectReference, usage reformav1beta1.ReferenceUsage) (permitted bool, err error) {
+
+	if patchManifest.GetNamespace() == "" || r.AllowCrossNamespaceReferences {
+		return true, err
+	}
+
+	// Scope is needed to decide, so references whose kind is unknown are never permitted
+	clusterScoped, err := r.isClusterScoped(reference)
+	if err != nil {
+		return permitted, err
+	}
+
+	// Cluster-scoped objects are outside any namespace, so they can not be granted
+	if clusterScoped {
+		return permitted, err
+	}
+
+	if getReferenceNamespace(patchManifest, reference) == patchManifest.GetNamespace() {
+		return true, err
+	}
+
+	return r.isReferenceGranted(ctx, patchManifest, reference, usage)
+}
+
+// checkReference verify that a namespaced Patch is allowed to use a referenced object
+func (r *PatchReconciler) checkReference(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	reference corev1.ObjectReference, usage reformav1beta1.ReferenceUsage) (err error) {
+
+	permitted, err := r.isReferencePermitted(ctx, patchManifest, reference, usage)
+	if err != nil || permitted {
+		return err
+	}
+
+	return NewErrorf(referenceNotPermittedError, patchManifest.GetName(), reference.Kind,
+		getReferenceNamespace(patchManifest, reference), reference.Name, usage)
+}
+
+// CheckReferences verify that a namespaced Patch only uses the given target and its sources when they live inside
+// its own namespace, or when they are granted to its namespace by a ReferenceGrant.
+// Sources with selector are not checked here: objects not permitted are excluded from them when listed.
+// ClusterPatches are not restricted, as only cluster administrators are expected to create them
+func (r *PatchReconciler) CheckReferences(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	targetReference corev1.ObjectReference) (err error) {
+
+	err = r.checkReference(ctx, patchManifest, targetReference, reformav1beta1.ReferenceUsageTarget)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range patchManifest.GetSpec().Sources {
+		if source.Selector != nil {
+			continue
+		}
+
+		err = r.checkReference(ctx, patchManifest, source.ObjectReference, reformav1beta1.ReferenceUsageSource)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// enqueueGrantedPatches return a reconcile request for each Patch in the namespaces of a ReferenceGrant,
+// as what they are allowed to reference may have changed
+func (r *PatchReconciler) enqueueGrantedPatches(ctx context.Context, obj client.Object) (requests []reconcile.Request) {
+	grant, ok := obj.(*reformav1beta1.ReferenceGrant)
+	if !ok {
+		return requests
+	}
+
+	for _, from := range grant.Spec.From {
+		patchList := &reformav1beta1.PatchList{}
+		err := r.List(ctx, patchList, client.InNamespace(from.Namespace))
+		if err != nil {
+			LogErrorf(ctx, err, grantedPatchesListError, grant.Name)
+			continue
+		}
+
+		for _, patchManifest := range patchList.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: patchManifest.Namespace,
+					Name:      patchManifest.Name,
+				},
+			})
+		}
+	}
+
+	return requests
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestRESTMapper return a mapper knowing some namespaced and cluster-scoped core kinds for the tests
+func newTestRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Secret"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+	return mapper
+}
+
+// newReferencesTestReconciler return a reconciler whose client holds the given ReferenceGrants
+func newReferencesTestReconciler(t *testing.T, grants ...reformav1beta1.ReferenceGrant) *PatchReconciler {
+	t.Helper()
+	builder := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithRESTMapper(newTestRESTMapper())
+	for i := range grants {
+		builder = builder.WithObjects(&grants[i])
+	}
+	return &PatchReconciler{Client: builder.Build()}
+}
+
+func TestIsReferenceGranted(t *testing.T) {
+	grant := func(from string, to reformav1beta1.ReferenceGrantTo) reformav1beta1.ReferenceGrant {
+		return reformav1beta1.ReferenceGrant{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shared", Name: "grant-" + from},
+			Spec: reformav1beta1.ReferenceGrantSpec{
+				From: []reformav1beta1.ReferenceGrantFrom{{Namespace: from}},
+				To:   []reformav1beta1.ReferenceGrantTo{to},
+			},
+		}
+	}
+	reference := corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "shared", Name: "config"}
+
+	tests := []struct {
+		name   string
+		grants []reformav1beta1.ReferenceGrant
+		usage  reformav1beta1.ReferenceUsage
+		want   bool
+	}{
+		{
+			name:  "no grants",
+			usage: reformav1beta1.ReferenceUsageSource,
+			want:  false,
+		},
+		{
+			name:   "kind granted to the namespace",
+			grants: []reformav1beta1.ReferenceGrant{grant("app", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap"})},
+			usage:  reformav1beta1.ReferenceUsageSource,
+			want:   true,
+		},
+		{
+			name:   "kind granted to another namespace",
+			grants: []reformav1beta1.ReferenceGrant{grant("other", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap"})},
+			usage:  reformav1beta1.ReferenceUsageSource,
+			want:   false,
+		},
+		{
+			name:   "another kind granted",
+			grants: []reformav1beta1.ReferenceGrant{grant("app", reformav1beta1.ReferenceGrantTo{Kind: "Secret"})},
+			usage:  reformav1beta1.ReferenceUsageSource,
+			want:   false,
+		},
+		{
+			name: "same kind in another group granted",
+			grants: []reformav1beta1.ReferenceGrant{
+				grant("app", reformav1beta1.ReferenceGrantTo{Group: "example.com", Kind: "ConfigMap"}),
+			},
+			usage: reformav1beta1.ReferenceUsageSource,
+			want:  false,
+		},
+		{
+			name:   "name granted",
+			grants: []reformav1beta1.ReferenceGrant{grant("app", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap", Name: "config"})},
+			usage:  reformav1beta1.ReferenceUsageSource,
+			want:   true,
+		},
+		{
+			name:   "another name granted",
+			grants: []reformav1beta1.ReferenceGrant{grant("app", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap", Name: "other"})},
+			usage:  reformav1beta1.ReferenceUsageSource,
+			want:   false,
+		},
+		{
+			name: "usage granted",
+			grants: []reformav1beta1.ReferenceGrant{
+				grant("app", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap", Usage: reformav1beta1.ReferenceUsageTarget}),
+			},
+			usage: reformav1beta1.ReferenceUsageTarget,
+			want:  true,
+		},
+		{
+			name: "another usage granted",
+			grants: []reformav1beta1.ReferenceGrant{
+				grant("app", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap", Usage: reformav1beta1.ReferenceUsageSource}),
+			},
+			usage: reformav1beta1.ReferenceUsageTarget,
+			want:  false,
+		},
+		{
+			name: "any of several grants",
+			grants: []reformav1beta1.ReferenceGrant{
+				grant("other", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap"}),
+				grant("app", reformav1beta1.ReferenceGrantTo{Kind: "ConfigMap", Name: "config"}),
+			},
+			usage: reformav1beta1.ReferenceUsageSource,
+			want:  true,
+		},
+	}
+
+	patchManifest := &reformav1beta1.Patch{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "patch"}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newReferencesTestReconciler(t, test.grants...)
+
+			granted, err := r.isReferenceGranted(context.Background(), patchManifest, reference, test.usage)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if granted != test.want {
+				t.Errorf("got %t, want %t", granted, test.want)
+			}
+		})
+	}
+}
+
+func TestIsReferencePermitted(t *testing.T) {
+	patchManifest := &reformav1beta1.Patch{ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "patch"}}
+
+	tests := []struct {
+		name      string
+		patch     reformav1beta1.PatchObject
+		reference corev1.ObjectReference
+		want      bool
+		wantErr   bool
+	}{
+		{
+			name:      "object in the same namespace",
+			patch:     patchManifest,
+			reference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "config"},
+			want:      true,
+		},
+		{
+			name:      "object in another namespace without grant",
+			patch:     patchManifest,
+			reference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: "shared", Name: "config"},
+			want:      false,
+		},
+		{
+			name:      "cluster-scoped object",
+			patch:     patchManifest,
+			reference: corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: "shared"},
+			want:      false,
+		},
+		{
+			name:      "unknown kind",
+			patch:     patchManifest,
+			reference: corev1.ObjectReference{APIVersion: "example.com/v1", Kind: "Unknown", Name: "object"},
+			want:      false,
+			wantErr:   true,
+		},
+		{
+			name:      "ClusterPatches are not restricted",
+			patch:     &reformav1beta1.ClusterPatch{ObjectMeta: metav1.ObjectMeta{Name: "patch"}},
+			reference: corev1.ObjectReference{APIVersion: "example.com/v1", Kind: "Unknown", Name: "object"},
+			want:      true,
+		},
+	}
+
+	r := newReferencesTestReconciler(t)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			permitted, err := r.isReferencePermitted(context.Background(), test.patch, test.reference,
+				reformav1beta1.ReferenceUsageSource)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if permitted != test.want {
+				t.Errorf("got %t, want %t", permitted, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// defaultReplacementFieldPath is the field of the source copied when no field path is defined
+	defaultReplacementFieldPath = "metadata.name"
+
+	replacementSourceNotFoundError = "Source with alias '%s' not found"
+	fieldPathInvalidError          = "Field path '%s' is not valid"
+	fieldPathNotFoundError         = "Field path '%s' not found"
+	fieldPathNotCreatableError     = "Field path '%s' can not be created, as it selects list items"
+	replacementNotStringError      = "Value of field path '%s' must be a string to be split by '%s'"
+	replacementIndexError          = "Index %d is out of bounds for the value of field path '%s' split by '%s'"
+)
+
+// jsonPatchOperation is one of the operations of a JSON patch
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// parseFieldPath split a field path into its segments. Dots inside brackets do not split the path
+func parseFieldPath(fieldPath string) (segments []string, err error) {
+	current := strings.Builder{}
+	inBrackets := false
+
+	for _, char := range fieldPath {
+		switch {
+		case char == '[' && !inBrackets:
+			if current.Len() > 0 {
+				segments = append(segments, current.String())
+				current.Reset()
+			}
+			inBrackets = true
+			current.WriteRune(char)
+		case char == ']' && inBrackets:
+			current.WriteRune(char)
+			inBrackets = false
+		case char == '.' && !inBrackets:
+			if current.Len() > 0 {
+				segments = append(segments, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(char)
+		}
+	}
+
+	if current.Len() > 0 {
+		segments = append(segments, current.String())
+	}
+
+	if inBrackets || len(segments) == 0 {
+		return segments, NewErrorf(fieldPathInvalidError, fieldPath)
+	}
+
+	return segments, err
+}
+
+// getSegmentSelector return the key and value of a '[key=value]' segment, or the key of a '[key]' one
+func getSegmentSelector(segment string) (key string, value string, isSelector bool) {
+	if !strings.HasPrefix(segment, "[") || !strings.HasSuffix(segment, "]") {
+		return segment, value, false
+	}
+
+	key, value, isSelector = strings.Cut(segment[1:len(segment)-1], "=")
+	return key, value, isSelector
+}
+
+// resolveSegment return the child of a value selected by a segment, the JSON pointer segment to it,
+// and a function to set it. The function is nil when the child can not be set
+func resolveSegment(value interface{}, segment string) (child interface{}, pointerSegment string, found bool, set func(interface{})) {
+	key, selectorValue, isSelector := getSegmentSelector(segment)
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if isSelector {
+			return child, pointerSegment, found, set
+		}
+		child, found = typedValue[key]
+		return child, jsonPointerEscaper.Replace(key), found, func(newChild interface{}) { typedValue[key] = newChild }
+
+	case []interface{}:
+		index := -1
+		if isSelector {
+			for i, item := range typedValue {
+				itemMap, ok := item.(map[string]interface{})
+				if ok && fmt.Sprint(itemMap[key]) == selectorValue {
+					index = i
+					break
+				}
+			}
+		} else if position, err := strconv.Atoi(key); err == nil && position >= 0 && position < len(typedValue) {
+			index = position
+		}
+
+		if index == -1 {
+			return child, pointerSegment, found, set
+		}
+		return typedValue[index], strconv.Itoa(index), true, func(newChild interface{}) { typedValue[index] = newChild }
+	}
+
+	return child, pointerSegment, found, set
+}
+
+// getFieldPath return the value of a field of an object
+func getFieldPath(object interface{}, fieldPath string) (value interface{}, err error) {
+	segments, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return value, err
+	}
+
+	value = object
+	for _, segment := range segments {
+		var found bool
+		value, _, found, _ = resolveSegment(value, segment)
+		if !found {
+			return value, NewErrorf(fieldPathNotFoundError, fieldPath)
+		}
+	}
+
+	return value, err
+}
+
+// setFieldPath set the value of a field of an object, and return the JSON patch operation doing the same.
+// Missing fields are created only when requested, and only when they are not list items
+func setFieldPath(object interface{}, fieldPath string, value interface{}, create bool) (operation jsonPatchOperation, err error) {
+	segments, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return operation, err
+	}
+
+	current := object
+	pointer := ""
+	for i, segment := range segments {
+		child, pointerSegment, found, set := resolveSegment(current, segment)
+		last := i == len(segments)-1
+
+		if !found || (child == nil && !last) {
+			if !create || set == nil {
+				return operation, NewErrorf(fieldPathNotFoundError, fieldPath)
+			}
+
+			// Build the missing fields from the deepest one
+			nestedValue := value
+			for j := len(segments) - 1; j > i; j-- {
+				key, _, isSelector := getSegmentSelector(segments[j])
+				if isSelector {
+					return operation, NewErrorf(fieldPathNotCreatableError, fieldPath)
+				}
+				nestedValue = map[string]interface{}{key: nestedValue}
+			}
+
+			set(nestedValue)
+			return jsonPatchOperation{Op: "add", Path: pointer + "/" + pointerSegment, Value: nestedValue}, err
+		}
+
+		if last {
+			set(value)
+			return jsonPatchOperation{Op: "replace", Path: pointer + "/" + pointerSegment, Value: value}, err
+		}
+
+		current = child
+		pointer += "/" + pointerSegment
+	}
+
+	return operation, err
+}
+
+// getValuePart return the part of a string value at the index of the options, when a delimiter is defined
+func getValuePart(value interface{}, fieldPath string, options *reformav1beta1.ReplacementOptions) (part interface{}, err error) {
+	if options == nil || options.Delimiter == "" {
+		return value, err
+	}
+
+	stringValue, ok := value.(string)
+	if !ok {
+		return part, NewErrorf(replacementNotStringError, fieldPath, options.Delimiter)
+	}
+
+	parts := strings.Split(stringValue, options.Delimiter)
+	if options.Index < 0 || options.Index >= len(parts) {
+		return part, NewErrorf(replacementIndexError, options.Index, fieldPath, options.Delimiter)
+	}
+
+	return parts[options.Index], err
+}
+
+// replaceValuePart return a string value with the part at the index of the options replaced, when a delimiter is defined
+func replaceValuePart(currentValue, value interface{}, fieldPath string,
+	options *reformav1beta1.ReplacementOptions) (replacedValue interface{}, err error) {
+
+	if options == nil || options.Delimiter == "" {
+		return value, err
+	}
+
+	stringValue, currentIsString := currentValue.(string)
+	partValue, valueIsString := value.(string)
+	if !currentIsString || !valueIsString {
+		return replacedValue, NewErrorf(replacementNotStringError, fieldPath, options.Delimiter)
+	}
+
+	parts := strings.Split(stringValue, options.Delimiter)
+	if options.Index < 0 || options.Index >= len(parts) {
+		return replacedValue, NewErrorf(replacementIndexError, options.Index, fieldPath, options.Delimiter)
+	}
+	parts[options.Index] = partValue
+
+	return strings.Join(parts, options.Delimiter), err
+}
+
+// getReplacementOperations return the JSON patch operations copying the value of a replacement into the target
+func getReplacementOperations(target interface{}, sources map[string]interface{},
+	replacement reformav1beta1.ReplacementSpec) (operations []jsonPatchOperation, err error) {
+
+	source, found := sources[replacement.Source.Alias]
+	if !found {
+		return operations, NewErrorf(replacementSourceNotFoundError, replacement.Source.Alias)
+	}
+
+	// Optional sources not found, and without default, have nothing to copy
+	if source == nil {
+		return operations, err
+	}
+
+	sourceFieldPath := replacement.Source.FieldPath
+	if sourceFieldPath == "" {
+		sourceFieldPath = defaultReplacementFieldPath
+	}
+
+	value, err := getFieldPath(source, sourceFieldPath)
+	if err != nil {
+		return operations, err
+	}
+
+	value, err = getValuePart(value, sourceFieldPath, replacement.Source.Options)
+	if err != nil {
+		return operations, err
+	}
+
+	for _, replacementTarget := range replacement.Targets {
+		create := replacementTarget.Options != nil && replacementTarget.Options.Create
+
+		for _, fieldPath := range replacementTarget.FieldPaths {
+			targetValue := value
+
+			// Only a part of the current value is replaced when a delimiter is defined
+			currentValue, getErr := getFieldPath(target, fieldPath)
+			if getErr == nil {
+				targetValue, err = replaceValuePart(currentValue, value, fieldPath, replacementTarget.Options)
+				if err != nil {
+					return operations, err
+				}
+			}
+
+			operation, err := setFieldPath(target, fieldPath, targetValue, create)
+			if err != nil {
+				return operations, err
+			}
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations, err
+}
+
+// GetReplacementsPatch return a JSON patch copying the values of the sources into the target, as defined
+// by the replacements of the Patch
+func (r *PatchReconciler) GetReplacementsPatch(patchManifest reformav1beta1.PatchObject, resources []interface{}) (patch string, err error) {
+
+	structuredContext, err := r.GetStructuredTemplateContext(patchManifest, resources)
+	if err != nil {
+		return patch, err
+	}
+
+	// Work on a copy of the target, so each replacement sees the changes done by the previous ones
+	target := runtime.DeepCopyJSONValue(structuredContext.Target)
+
+	operations := []jsonPatchOperation{}
+	for _, replacement := range patchManifest.GetSpec().Replacements {
+		replacementOperations, err := getReplacementOperations(target, structuredContext.Sources, replacement)
+		if err != nil {
+			return patch, err
+		}
+		operations = append(operations, replacementOperations...)
+	}
+
+	patchBytes, err := json.Marshal(operations)
+	if err != nil {
+		return patch, err
+	}
+
+	return string(patchBytes), err
+}
@@ -0,0 +1,382 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		fieldPath string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "dot-separated keys",
+			fieldPath: "metadata.name",
+			want:      []string{"metadata", "name"},
+		},
+		{
+			name:      "bracket keys containing dots",
+			fieldPath: "metadata.annotations[example.com/a.b]",
+			want:      []string{"metadata", "annotations", "[example.com/a.b]"},
+		},
+		{
+			name:      "list selectors",
+			fieldPath: "spec.containers[name=app].image",
+			want:      []string{"spec", "containers", "[name=app]", "image"},
+		},
+		{
+			name:      "list positions",
+			fieldPath: "spec.containers.0.image",
+			want:      []string{"spec", "containers", "0", "image"},
+		},
+		{
+			name:      "unclosed brackets",
+			fieldPath: "metadata.annotations[example.com/a",
+			wantErr:   true,
+		},
+		{
+			name:      "empty path",
+			fieldPath: "",
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segments, err := parseFieldPath(test.fieldPath)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(segments, test.want) {
+				t.Errorf("got %q, want %q", segments, test.want)
+			}
+		})
+	}
+}
+
+func TestGetFieldPath(t *testing.T) {
+	object := `{
+		"metadata": {"name": "app", "annotations": {"example.com/a.b": "dotted"}},
+		"spec": {"containers": [{"name": "sidecar", "image": "proxy"}, {"name": "app", "image": "nginx:1.0"}]}
+	}`
+
+	tests := []struct {
+		name      string
+		fieldPath string
+		want      interface{}
+		wantErr   bool
+	}{
+		{
+			name:      "map keys",
+			fieldPath: "metadata.name",
+			want:      "app",
+		},
+		{
+			name:      "bracket keys containing dots",
+			fieldPath: "metadata.annotations[example.com/a.b]",
+			want:      "dotted",
+		},
+		{
+			name:      "list selectors",
+			fieldPath: "spec.containers[name=app].image",
+			want:      "nginx:1.0",
+		},
+		{
+			name:      "list positions",
+			fieldPath: "spec.containers.0.image",
+			want:      "proxy",
+		},
+		{
+			name:      "list selectors not matching",
+			fieldPath: "spec.containers[name=missing].image",
+			wantErr:   true,
+		},
+		{
+			name:      "list positions out of range",
+			fieldPath: "spec.containers.2.image",
+			wantErr:   true,
+		},
+		{
+			name:      "selectors on maps",
+			fieldPath: "metadata[name=app]",
+			wantErr:   true,
+		},
+		{
+			name:      "missing keys",
+			fieldPath: "metadata.labels.app",
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := getFieldPath(decodeObject(t, object), test.fieldPath)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(value, test.want) {
+				t.Errorf("got %v, want %v", value, test.want)
+			}
+		})
+	}
+}
+
+func TestSetFieldPath(t *testing.T) {
+	object := `{
+		"metadata": {"name": "app", "labels": null},
+		"spec": {"containers": [{"name": "sidecar", "image": "proxy"}, {"name": "app", "image": "nginx:1.0"}]}
+	}`
+
+	tests := []struct {
+		name      string
+		fieldPath string
+		create    bool
+		want      jsonPatchOperation
+		wantErr   string
+	}{
+		{
+			name:      "existing fields are replaced",
+			fieldPath: "metadata.name",
+			want:      jsonPatchOperation{Op: "replace", Path: "/metadata/name", Value: "value"},
+		},
+		{
+			name:      "list items are replaced by selector",
+			fieldPath: "spec.containers[name=app].image",
+			want:      jsonPatchOperation{Op: "replace", Path: "/spec/containers/1/image", Value: "value"},
+		},
+		{
+			name:      "missing fields are not created by default",
+			fieldPath: "metadata.annotations.a",
+			wantErr:   "not found",
+		},
+		{
+			name:      "missing leaves are created",
+			fieldPath: "spec.containers[name=app].command",
+			create:    true,
+			want:      jsonPatchOperation{Op: "add", Path: "/spec/containers/1/command", Value: "value"},
+		},
+		{
+			name:      "nested missing maps are created",
+			fieldPath: "metadata.annotations[example.com/a.b]",
+			create:    true,
+			want: jsonPatchOperation{Op: "add", Path: "/metadata/annotations",
+				Value: map[string]interface{}{"example.com/a.b": "value"}},
+		},
+		{
+			name:      "null maps are created",
+			fieldPath: "metadata.labels.a",
+			create:    true,
+			want:      jsonPatchOperation{Op: "add", Path: "/metadata/labels", Value: map[string]interface{}{"a": "value"}},
+		},
+		{
+			name:      "deeply nested missing maps are created",
+			fieldPath: "spec.template.metadata.labels.a",
+			create:    true,
+			want: jsonPatchOperation{Op: "add", Path: "/spec/template", Value: map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"a": "value"}},
+			}},
+		},
+		{
+			name:      "selectors under created fields can not be created",
+			fieldPath: "spec.initContainers[name=app].image",
+			create:    true,
+			wantErr:   "can not be created",
+		},
+		{
+			name:      "list items not matching can not be created",
+			fieldPath: "spec.containers[name=missing].image",
+			create:    true,
+			wantErr:   "not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := decodeObject(t, object)
+
+			operation, err := setFieldPath(target, test.fieldPath, "value", test.create)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(operation, test.want) {
+				t.Errorf("got %+v, want %+v", operation, test.want)
+			}
+
+			// The target is changed too, so following replacements see the value
+			value, err := getFieldPath(target, test.fieldPath)
+			if err != nil || value != "value" {
+				t.Errorf("got value %v with error %v in the target, want the set value", value, err)
+			}
+		})
+	}
+}
+
+func TestReplaceValueParts(t *testing.T) {
+	tests := []struct {
+		name         string
+		currentValue interface{}
+		options      *reformav1beta1.ReplacementOptions
+		wantPart     interface{}
+		wantReplaced interface{}
+		wantErr      string
+	}{
+		{
+			name:         "whole values without delimiter",
+			currentValue: "nginx:1.0",
+			wantPart:     "nginx:1.0",
+			wantReplaced: "value",
+		},
+		{
+			name:         "parts at the index",
+			currentValue: "nginx:1.0",
+			options:      &reformav1beta1.ReplacementOptions{Delimiter: ":", Index: 1},
+			wantPart:     "1.0",
+			wantReplaced: "nginx:value",
+		},
+		{
+			name:         "index out of range",
+			currentValue: "nginx:1.0",
+			options:      &reformav1beta1.ReplacementOptions{Delimiter: ":", Index: 2},
+			wantErr:      "out of bounds",
+		},
+		{
+			name:         "negative index",
+			currentValue: "nginx:1.0",
+			options:      &reformav1beta1.ReplacementOptions{Delimiter: ":", Index: -1},
+			wantErr:      "out of bounds",
+		},
+		{
+			name:         "values that are not strings",
+			currentValue: float64(1),
+			options:      &reformav1beta1.ReplacementOptions{Delimiter: ":"},
+			wantErr:      "must be a string",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			part, partErr := getValuePart(test.currentValue, "spec.image", test.options)
+			replaced, replaceErr := replaceValuePart(test.currentValue, "value", "spec.image", test.options)
+
+			if test.wantErr != "" {
+				for _, err := range []error{partErr, replaceErr} {
+					if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+						t.Errorf("got error %v, want error containing %q", err, test.wantErr)
+					}
+				}
+				return
+			}
+			if partErr != nil || replaceErr != nil {
+				t.Fatalf("unexpected errors: %v, %v", partErr, replaceErr)
+			}
+
+			if part != test.wantPart {
+				t.Errorf("got part %v, want %v", part, test.wantPart)
+			}
+			if replaced != test.wantReplaced {
+				t.Errorf("got replaced value %v, want %v", replaced, test.wantReplaced)
+			}
+		})
+	}
+}
+
+func TestGetReplacementOperations(t *testing.T) {
+	target := `{"metadata": {"name": "app"}, "spec": {"containers": [{"name": "app", "image": "nginx:1.0"}]}}`
+
+	sources := map[string]interface{}{
+		"release": map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "release"},
+			"data":     map[string]interface{}{"version": "2.0"},
+		},
+
+		// Optional sources not found, and without default
+		"missing": nil,
+	}
+
+	tests := []struct {
+		name        string
+		replacement reformav1beta1.ReplacementSpec
+		want        []jsonPatchOperation
+		wantErr     string
+	}{
+		{
+			name: "parts of the target are replaced",
+			replacement: reformav1beta1.ReplacementSpec{
+				Source: reformav1beta1.ReplacementSourceSpec{Alias: "release", FieldPath: "data.version"},
+				Targets: []reformav1beta1.ReplacementTargetSpec{{
+					FieldPaths: []string{"spec.containers[name=app].image"},
+					Options:    &reformav1beta1.ReplacementOptions{Delimiter: ":", Index: 1},
+				}},
+			},
+			want: []jsonPatchOperation{{Op: "replace", Path: "/spec/containers/0/image", Value: "nginx:2.0"}},
+		},
+		{
+			name: "name of the source is copied by default",
+			replacement: reformav1beta1.ReplacementSpec{
+				Source: reformav1beta1.ReplacementSourceSpec{Alias: "release"},
+				Targets: []reformav1beta1.ReplacementTargetSpec{{
+					FieldPaths: []string{"metadata.labels.release"},
+					Options:    &reformav1beta1.ReplacementOptions{Create: true},
+				}},
+			},
+			want: []jsonPatchOperation{{Op: "add", Path: "/metadata/labels",
+				Value: map[string]interface{}{"release": "release"}}},
+		},
+		{
+			name: "optional sources without default copy nothing",
+			replacement: reformav1beta1.ReplacementSpec{
+				Source:  reformav1beta1.ReplacementSourceSpec{Alias: "missing", FieldPath: "data.version"},
+				Targets: []reformav1beta1.ReplacementTargetSpec{{FieldPaths: []string{"metadata.name"}}},
+			},
+			want: nil,
+		},
+		{
+			name: "unknown sources",
+			replacement: reformav1beta1.ReplacementSpec{
+				Source:  reformav1beta1.ReplacementSourceSpec{Alias: "unknown"},
+				Targets: []reformav1beta1.ReplacementTargetSpec{{FieldPaths: []string{"metadata.name"}}},
+			},
+			wantErr: "not found",
+		},
+		{
+			name: "missing source fields",
+			replacement: reformav1beta1.ReplacementSpec{
+				Source:  reformav1beta1.ReplacementSourceSpec{Alias: "release", FieldPath: "data.missing"},
+				Targets: []reformav1beta1.ReplacementTargetSpec{{FieldPaths: []string{"metadata.name"}}},
+			},
+			wantErr: "not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operations, err := getReplacementOperations(decodeObject(t, target), sources, test.replacement)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(operations, test.want) {
+				t.Errorf("got %+v, want %+v", operations, test.want)
+			}
+		})
+	}
+}
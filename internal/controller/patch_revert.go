@@ -0,0 +1,666 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// abandonRevertAnnotation let a Patch be deleted leaving as they are the targets it is not allowed to restore anymore
+	abandonRevertAnnotation = "reforma.prosimcorp.com/abandon-revert"
+
+	// listItemKeyPrefix and listItemValuePrefix start the path segments identifying list items by their merge key,
+	// or by their whole value, as done by Kubernetes in the managed fields
+	listItemKeyPrefix   = "k:"
+	listItemValuePrefix = "v:"
+
+	// untypedListMergeKey is the merge key guessed for lists of objects whose kind is unknown, when all their items have it
+	untypedListMergeKey = "name"
+
+	targetRevertAbandonedError = "Target %s %s/%s was left as it is while deleting the Patch"
+)
+
+var (
+	// revertIgnoredPaths store the paths changed by Kubernetes itself on each patch, or not patchable at all
+	revertIgnoredPaths = map[string]bool{
+		"/metadata/resourceVersion": true,
+		"/metadata/managedFields":   true,
+		"/metadata/generation":      true,
+		"/status":                   true,
+	}
+
+	// secretValuePaths store the paths of Secrets whose values are never recorded
+	secretValuePaths = []string{"/data", "/stringData"}
+
+	// jsonPointerEscaper and jsonPointerUnescaper convert keys into JSON pointer segments, and back
+	jsonPointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	jsonPointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// getFieldPatchMeta return the patch metadata of a field of a typed object, or nil when the type is unknown
+func getFieldPatchMeta(patchMeta strategicpatch.LookupPatchMeta, key string) strategicpatch.LookupPatchMeta {
+	if patchMeta == nil {
+		return nil
+	}
+
+	fieldPatchMeta, _, err := patchMeta.LookupPatchMetadataForStruct(key)
+	if err != nil {
+		return nil
+	}
+	return fieldPatchMeta
+}
+
+// getListItemsPatchMeta return the patch metadata of the items of a list inside a typed object, and how the list is merged.
+// Lists of unknown types get a nil metadata
+func getListItemsPatchMeta(patchMeta strategicpatch.LookupPatchMeta, key string) (itemsPatchMeta strategicpatch.LookupPatchMeta,
+	listPatchMeta *strategicpatch.PatchMeta) {
+
+	if patchMeta == nil {
+		return itemsPatchMeta, listPatchMeta
+	}
+
+	itemsPatchMeta, listMeta, err := patchMeta.LookupPatchMetadataForSlice(key)
+	if err != nil {
+		return nil, listPatchMeta
+	}
+	return itemsPatchMeta, &listMeta
+}
+
+// getListMergeKey return how the items of a list are identified: by the value of a key for lists of objects,
+// or by their whole value for sets of scalars. Lists whose items can not be identified are atomic, so neither is returned.
+// Typed lists follow their patch strategy, while unknown ones are guessed from their items
+func getListMergeKey(listPatchMeta *strategicpatch.PatchMeta, before, after []interface{}) (mergeKey string, isSet bool) {
+	items := append(slices.Clone(before), after...)
+
+	allScalars, allKeyed := true, true
+	for _, item := range items {
+		itemMap, isMap := item.(map[string]interface{})
+		_, isList := item.([]interface{})
+		if isMap || isList {
+			allScalars = false
+		}
+		if !isMap || itemMap[untypedListMergeKey] == nil {
+			allKeyed = false
+		}
+	}
+
+	if listPatchMeta != nil {
+		mergeKey = listPatchMeta.GetPatchMergeKey()
+		isMerged := slices.Contains(listPatchMeta.GetPatchStrategies(), "merge")
+		return mergeKey, isMerged && mergeKey == "" && allScalars
+	}
+
+	if allKeyed && len(items) > 0 {
+		return untypedListMergeKey, false
+	}
+	return mergeKey, allScalars
+}
+
+// getListItemSegment return the path segment identifying a list item, by the value of its merge key,
+// or by its whole value when there is no merge key
+func getListItemSegment(item interface{}, mergeKey string) (segment string, err error) {
+	if mergeKey == "" {
+		rawValue, err := json.Marshal(item)
+		return listItemValuePrefix + string(rawValue), err
+	}
+
+	itemMap, ok := item.(map[string]interface{})
+	if !ok {
+		return segment, errors.New("list item without merge key " + mergeKey)
+	}
+	rawKey, err := json.Marshal(map[string]interface{}{mergeKey: itemMap[mergeKey]})
+	return listItemKeyPrefix + string(rawKey), err
+}
+
+// getListItems return the items of a list indexed by their path segment. Lists with repeated segments are not
+// returned, as their items can not be told apart
+func getListItems(list []interface{}, mergeKey string) (items map[string]interface{}, err error) {
+	items = map[string]interface{}{}
+	for _, item := range list {
+		segment, err := getListItemSegment(item, mergeKey)
+		if err != nil {
+			return nil, err
+		}
+		if _, repeated := items[segment]; repeated {
+			return nil, err
+		}
+		items[segment] = item
+	}
+	return items, err
+}
+
+// getChangedItems fill the paths list with the items that differ between two lists, identified by their merge key or value.
+// Items of objects are walked recursively. It returns whether the items could be compared one by one,
+// as atomic lists are compared as a whole instead
+func getChangedItems(before, after []interface{}, itemsPatchMeta strategicpatch.LookupPatchMeta,
+	listPatchMeta *strategicpatch.PatchMeta, prefix string, paths *[]reformav1beta1.RevertPath) (compared bool, err error) {
+
+	mergeKey, isSet := getListMergeKey(listPatchMeta, before, after)
+	if mergeKey == "" && !isSet {
+		return compared, err
+	}
+
+	beforeItems, err := getListItems(before, mergeKey)
+	if err != nil || beforeItems == nil {
+		return compared, nil
+	}
+	afterItems, err := getListItems(after, mergeKey)
+	if err != nil || afterItems == nil {
+		return compared, nil
+	}
+
+	segments := map[string]bool{}
+	for segment := range beforeItems {
+		segments[segment] = true
+	}
+	for segment := range afterItems {
+		segments[segment] = true
+	}
+
+	for segment := range segments {
+		path := prefix + "/" + jsonPointerEscaper.Replace(segment)
+
+		beforeItem, existed := beforeItems[segment]
+		afterItem, exists := afterItems[segment]
+
+		if reflect.DeepEqual(beforeItem, afterItem) {
+			continue
+		}
+
+		// Items changed by the patch keep their identity, so only their changed fields are recorded
+		beforeMap, beforeIsMap := beforeItem.(map[string]interface{})
+		afterMap, afterIsMap := afterItem.(map[string]interface{})
+		if existed && exists && beforeIsMap && afterIsMap {
+			err = getChangedPaths(beforeMap, afterMap, itemsPatchMeta, path, paths)
+			if err != nil {
+				return compared, err
+			}
+			continue
+		}
+
+		revertPath := reformav1beta1.RevertPath{Path: path}
+		if existed {
+			rawValue, err := json.Marshal(beforeItem)
+			if err != nil {
+				return compared, err
+			}
+			revertPath.Value = &apiextensionsv1.JSON{Raw: rawValue}
+		}
+
+		*paths = append(*paths, revertPath)
+	}
+
+	return true, err
+}
+
+// getChangedPaths fill the paths list with the fields that differ between two objects and their value in the first one.
+// Maps are walked recursively. Items of lists are identified by their merge key, or by their value in sets of scalars,
+// so only the items changed are recorded. The rest of the lists, and other values, are compared as a whole.
+// Fields not existing in the first object are recorded without value
+func getChangedPaths(before, after map[string]interface{}, patchMeta strategicpatch.LookupPatchMeta,
+	prefix string, paths *[]reformav1beta1.RevertPath) (err error) {
+
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		path := prefix + "/" + jsonPointerEscaper.Replace(key)
+		if revertIgnoredPaths[path] {
+			continue
+		}
+
+		beforeValue, existed := before[key]
+		afterValue := after[key]
+
+		if reflect.DeepEqual(beforeValue, afterValue) {
+			continue
+		}
+
+		beforeMap, beforeIsMap := beforeValue.(map[string]interface{})
+		afterMap, afterIsMap := afterValue.(map[string]interface{})
+
+		// Maps and lists created by the patch are walked too, so only the added fields and items are removed on revert,
+		// and not the ones added to the same map or list by others afterwards
+		if !existed && afterIsMap {
+			beforeMap, beforeIsMap = map[string]interface{}{}, true
+		}
+
+		if beforeIsMap && afterIsMap {
+			err = getChangedPaths(beforeMap, afterMap, getFieldPatchMeta(patchMeta, key), path, paths)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		beforeList, beforeIsList := beforeValue.([]interface{})
+		afterList, afterIsList := afterValue.([]interface{})
+		if !existed && afterIsList {
+			beforeList, beforeIsList = []interface{}{}, true
+		}
+
+		if beforeIsList && afterIsList {
+			itemsPatchMeta, listPatchMeta := getListItemsPatchMeta(patchMeta, key)
+			compared, err := getChangedItems(beforeList, afterList, itemsPatchMeta, listPatchMeta, path, paths)
+			if err != nil {
+				return err
+			}
+			if compared {
+				continue
+			}
+		}
+
+		revertPath := reformav1beta1.RevertPath{Path: path}
+		if existed {
+			rawValue, err := json.Marshal(beforeValue)
+			if err != nil {
+				return err
+			}
+			revertPath.Value = &apiextensionsv1.JSON{Raw: rawValue}
+		}
+
+		*paths = append(*paths, revertPath)
+	}
+
+	return err
+}
+
+// isPathRecorded return whether the path, or any of its parents, is already in the list
+func isPathRecorded(paths []reformav1beta1.RevertPath, path string) bool {
+	for _, recordedPath := range paths {
+		if path == recordedPath.Path || strings.HasPrefix(path, recordedPath.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecretValuePath return whether a path of a target points to the values of a Secret, or to one of them
+func isSecretValuePath(gvk schema.GroupVersionKind, path string) bool {
+	if gvk.GroupKind() != corev1.SchemeGroupVersion.WithKind("Secret").GroupKind() {
+		return false
+	}
+
+	for _, secretValuePath := range secretValuePaths {
+		if path == secretValuePath || strings.HasPrefix(path, secretValuePath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// getTargetRevertStatus return the revert status of a target, creating it when not existent
+func getTargetRevertStatus(patchManifest reformav1beta1.PatchObject, target *unstructured.Unstructured) *reformav1beta1.TargetRevertStatus {
+	status := patchManifest.GetStatus()
+
+	for i, revert := range status.Reverts {
+		if revert.APIVersion == target.GetAPIVersion() && revert.Kind == target.GetKind() &&
+			revert.Namespace == target.GetNamespace() && revert.Name == target.GetName() {
+			return &status.Reverts[i]
+		}
+	}
+
+	status.Reverts = append(status.Reverts, reformav1beta1.TargetRevertStatus{
+		APIVersion: target.GetAPIVersion(),
+		Kind:       target.GetKind(),
+		Namespace:  target.GetNamespace(),
+		Name:       target.GetName(),
+		Paths:      []reformav1beta1.RevertPath{},
+	})
+	return &status.Reverts[len(status.Reverts)-1]
+}
+
+// getTargetPatchMeta return the patch metadata of the kind of a target, to know how its lists are merged.
+// Kinds not registered in the scheme, like custom resources, get a nil metadata
+func (r *PatchReconciler) getTargetPatchMeta(target *unstructured.Unstructured) strategicpatch.LookupPatchMeta {
+	if r.Scheme == nil {
+		return nil
+	}
+
+	typedTarget, err := r.Scheme.New(target.GroupVersionKind())
+	if err != nil {
+		return nil
+	}
+
+	patchMeta, err := strategicpatch.NewPatchMetaFromStruct(typedTarget)
+	if err != nil {
+		return nil
+	}
+	return patchMeta
+}
+
+// RecordRevertPaths store the previous values of the fields changed on a target by the patch.
+// Only the first value seen for each field is kept, as it is the one existing before Reforma touched it.
+// Values of Secrets are never stored: their added keys are removed on revert, but changed ones are left as they are.
+// It returns whether any new path was recorded
+func (r *PatchReconciler) RecordRevertPaths(patchManifest reformav1beta1.PatchObject, before, after *unstructured.Unstructured) (recorded bool, err error) {
+
+	if patchManifest.GetSpec().DeletionPolicy != reformav1beta1.DeletionPolicyRevert {
+		return recorded, err
+	}
+
+	var changedPaths []reformav1beta1.RevertPath
+	err = getChangedPaths(before.Object, after.Object, r.getTargetPatchMeta(before), "", &changedPaths)
+	if err != nil {
+		return recorded, err
+	}
+
+	if len(changedPaths) == 0 {
+		return recorded, err
+	}
+
+	// Parents go first, so their children are not recorded twice
+	sort.Slice(changedPaths, func(i, j int) bool {
+		return changedPaths[i].Path < changedPaths[j].Path
+	})
+
+	targetRevert := getTargetRevertStatus(patchManifest, before)
+	for _, changedPath := range changedPaths {
+		if changedPath.Value != nil && isSecretValuePath(before.GroupVersionKind(), changedPath.Path) {
+			continue
+		}
+		if !isPathRecorded(targetRevert.Paths, changedPath.Path) {
+			targetRevert.Paths = append(targetRevert.Paths, changedPath)
+			recorded = true
+		}
+	}
+
+	return recorded, err
+}
+
+// persistRevertStatus store the recorded reverts in the status of the Patch right away, without waiting for the end
+// of the synchronization. Only the reverts are sent, and the Patch keeps the new resource version to update it later
+func (r *PatchReconciler) persistRevertStatus(ctx context.Context, patchManifest reformav1beta1.PatchObject) (err error) {
+	revertPatch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"reverts": patchManifest.GetStatus().Reverts},
+	})
+	if err != nil {
+		return err
+	}
+
+	persistedPatch := patchManifest.DeepCopyObject().(reformav1beta1.PatchObject)
+	err = r.Status().Patch(ctx, persistedPatch, client.RawPatch(types.MergePatchType, revertPatch))
+	if err != nil {
+		return err
+	}
+
+	patchManifest.SetResourceVersion(persistedPatch.GetResourceVersion())
+	return err
+}
+
+// RecordRevertPathsBeforePatch store the previous values of the fields a patch is about to change on a target,
+// and persist them before patching it, so the changes can be reverted even when the synchronization fails afterwards.
+// The patch is sent as a dry run to know what it would change
+func (r *PatchReconciler) RecordRevertPathsBeforePatch(ctx context.Context, patchClient client.Client,
+	patchManifest reformav1beta1.PatchObject, previousTarget *unstructured.Unstructured, step reformav1beta1.PatchStepSpec,
+	parsedPatch []byte) (err error) {
+
+	dryRunTarget := previousTarget.DeepCopy()
+	err = patchClient.Patch(ctx, dryRunTarget, client.RawPatch(step.PatchType, parsedPatch), client.DryRunAll)
+	if err != nil {
+		r.updatePatchFailedCondition(patchManifest, step, err)
+		return err
+	}
+
+	recorded, err := r.RecordRevertPaths(patchManifest, previousTarget, dryRunTarget)
+	if err != nil || !recorded {
+		return err
+	}
+
+	err = r.persistRevertStatus(ctx, patchManifest)
+	if err != nil {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonRevertNotRecorded,
+			fmt.Sprintf(ConditionReasonRevertNotRecordedMessage, err.Error()),
+		))
+	}
+	return err
+}
+
+// isListItemSegment return whether a path segment identifies a list item
+func isListItemSegment(segment string) bool {
+	return strings.HasPrefix(segment, listItemKeyPrefix) || strings.HasPrefix(segment, listItemValuePrefix)
+}
+
+// isListItem return whether a list item is the one identified by a path segment
+func isListItem(item interface{}, segment string) bool {
+	if strings.HasPrefix(segment, listItemValuePrefix) {
+		rawValue, err := json.Marshal(item)
+		return err == nil && string(rawValue) == strings.TrimPrefix(segment, listItemValuePrefix)
+	}
+
+	itemKey := map[string]interface{}{}
+	err := json.Unmarshal([]byte(strings.TrimPrefix(segment, listItemKeyPrefix)), &itemKey)
+	if err != nil || len(itemKey) == 0 {
+		return false
+	}
+
+	for mergeKey := range itemKey {
+		segmentOfItem, err := getListItemSegment(item, mergeKey)
+		if err != nil || segmentOfItem != segment {
+			return false
+		}
+	}
+	return true
+}
+
+// restorePath return the node with the field or list item at the given path set to its recorded value,
+// or removed when it did not exist before. Paths through fields or items that do not exist anymore are left as they are
+func restorePath(node interface{}, segments []string, value interface{}, existed bool) interface{} {
+	segment := jsonPointerUnescaper.Replace(segments[0])
+	last := len(segments) == 1
+
+	switch typedNode := node.(type) {
+	case map[string]interface{}:
+		if last && existed {
+			typedNode[segment] = value
+			return typedNode
+		}
+		if last {
+			delete(typedNode, segment)
+			return typedNode
+		}
+
+		child, found := typedNode[segment]
+		if !found && !existed {
+			return typedNode
+		}
+
+		// Parents removed by others are created again to restore the field
+		if !found && isListItemSegment(jsonPointerUnescaper.Replace(segments[1])) {
+			child = []interface{}{}
+		} else if !found {
+			child = map[string]interface{}{}
+		}
+
+		typedNode[segment] = restorePath(child, segments[1:], value, existed)
+		return typedNode
+
+	case []interface{}:
+		if !isListItemSegment(segment) {
+			return typedNode
+		}
+
+		restoredItems := []interface{}{}
+		found := false
+		for _, item := range typedNode {
+			if !isListItem(item, segment) {
+				restoredItems = append(restoredItems, item)
+				continue
+			}
+
+			found = true
+			switch {
+			case last && existed:
+				restoredItems = append(restoredItems, value)
+			case !last:
+				restoredItems = append(restoredItems, restorePath(item, segments[1:], value, existed))
+			}
+		}
+
+		if !found && last && existed {
+			restoredItems = append(restoredItems, value)
+		}
+		return restoredItems
+	}
+
+	return node
+}
+
+// restoreRevertPaths set the recorded values on the fields and list items of an object, removing the ones
+// that did not exist before
+func restoreRevertPaths(object map[string]interface{}, paths []reformav1beta1.RevertPath) (err error) {
+	for _, revertPath := range paths {
+		var value interface{}
+		if revertPath.Value != nil {
+			err = json.Unmarshal(revertPath.Value.Raw, &value)
+			if err != nil {
+				return err
+			}
+		}
+
+		segments := strings.Split(strings.TrimPrefix(revertPath.Path, "/"), "/")
+		restorePath(object, segments, value, revertPath.Value != nil)
+	}
+
+	return err
+}
+
+// isTargetRevertAbandoned return whether restoring a target while deleting the Patch must be given up.
+// Targets not found are already gone, and targets the Patch is not allowed to restore anymore are only left
+// as they are when the Patch is annotated to do so, as it happens when deleting its namespace
+func isTargetRevertAbandoned(patchManifest reformav1beta1.PatchObject, err error) bool {
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+	return apierrors.IsForbidden(err) && patchManifest.GetAnnotations()[abandonRevertAnnotation] == "true"
+}
+
+// abandonTargetRevert report a target left as it is while deleting the Patch, as it can not be restored.
+// Targets not found are not reported, as there is nothing left to restore
+func (r *PatchReconciler) abandonTargetRevert(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	target *unstructured.Unstructured, err error) {
+
+	if apierrors.IsNotFound(err) {
+		return
+	}
+
+	LogErrorf(ctx, err, targetRevertAbandonedError, target.GetKind(), target.GetNamespace(), target.GetName())
+
+	if r.Recorder == nil {
+		return
+	}
+
+	r.emitTargetEvent(patchManifest, reformav1beta1.TargetStatus{
+		APIVersion: target.GetAPIVersion(),
+		Kind:       target.GetKind(),
+		Namespace:  target.GetNamespace(),
+		Name:       target.GetName(),
+	}, "", corev1.EventTypeWarning, ConditionReasonRevertAbandoned,
+		fmt.Sprintf(ConditionReasonRevertAbandonedMessage, err.Error()))
+}
+
+// revertTarget restore the recorded fields of a target as it is now. The restored target is sent as a merge patch
+// guarded by its resource version, so changes done by others in the meantime are never overwritten
+func revertTarget(ctx context.Context, patchClient client.Client, target *unstructured.Unstructured,
+	targetRevert reformav1beta1.TargetRevertStatus) (err error) {
+
+	currentTarget := target.DeepCopy()
+	err = patchClient.Get(ctx, client.ObjectKeyFromObject(target), currentTarget)
+	if err != nil {
+		return err
+	}
+
+	revertedTarget := currentTarget.DeepCopy()
+	err = restoreRevertPaths(revertedTarget.Object, targetRevert.Paths)
+	if err != nil {
+		return err
+	}
+
+	return patchClient.Patch(ctx, revertedTarget,
+		client.MergeFromWithOptions(currentTarget, client.MergeFromWithOptimisticLock{}))
+}
+
+// RevertTargets restore the fields touched on all the targets to the values they had before being patched,
+// and delete the targets created by the Patch. Targets that do not exist anymore are considered reverted.
+// Targets the Patch is not allowed to restore anymore keep the Patch, unless it is annotated to abandon them
+func (r *PatchReconciler) RevertTargets(ctx context.Context, patchManifest reformav1beta1.PatchObject) (err error) {
+
+	if patchManifest.GetSpec().DeletionPolicy != reformav1beta1.DeletionPolicyRevert {
+		return err
+	}
+
+	patchClient, err := r.GetPatchClient(patchManifest)
+	if err != nil {
+		return err
+	}
+
+	var revertErrors []error
+	for _, targetRevert := range patchManifest.GetStatus().Reverts {
+		target := &unstructured.Unstructured{}
+		target.SetGroupVersionKind((&corev1.ObjectReference{
+			APIVersion: targetRevert.APIVersion,
+			Kind:       targetRevert.Kind,
+		}).GroupVersionKind())
+		target.SetNamespace(targetRevert.Namespace)
+		target.SetName(targetRevert.Name)
+
+		// Targets created by the Patch did not exist before it
+		if targetRevert.Created {
+			err = patchClient.Delete(ctx, target)
+		} else {
+			err = revertTarget(ctx, patchClient, target, targetRevert)
+		}
+
+		if err != nil && isTargetRevertAbandoned(patchManifest, err) {
+			r.abandonTargetRevert(ctx, patchManifest, target, err)
+		} else if err != nil {
+			revertErrors = append(revertErrors, err)
+		}
+	}
+
+	err = errors.Join(revertErrors...)
+	if err == nil {
+		return err
+	}
+
+	if slices.ContainsFunc(revertErrors, apierrors.IsForbidden) {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonRevertForbidden,
+			fmt.Sprintf(ConditionReasonRevertForbiddenMessage, abandonRevertAnnotation),
+		))
+		return err
+	}
+
+	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+		metav1.ConditionFalse,
+		ConditionReasonRevertFailed,
+		ConditionReasonRevertFailedMessage,
+	))
+	return err
+}
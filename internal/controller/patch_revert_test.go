@@ -0,0 +1,493 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// decodeObject decode a JSON object for the tests, failing them when it is not valid
+func decodeObject(t *testing.T, raw string) (object map[string]interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(raw), &object); err != nil {
+		t.Fatalf("invalid test object %s: %v", raw, err)
+	}
+	return object
+}
+
+// revertValue return the recorded value of a path for the tests
+func revertValue(raw string) *apiextensionsv1.JSON {
+	return &apiextensionsv1.JSON{Raw: []byte(raw)}
+}
+
+// getSortedChangedPaths return the changed paths between two JSON objects, sorted to compare them
+func getSortedChangedPaths(t *testing.T, r *PatchReconciler, kind schema.GroupVersionKind, before, after string) (paths []reformav1beta1.RevertPath) {
+	t.Helper()
+
+	target := &unstructured.Unstructured{}
+	target.SetGroupVersionKind(kind)
+
+	err := getChangedPaths(decodeObject(t, before), decodeObject(t, after), r.getTargetPatchMeta(target), "", &paths)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Path < paths[j].Path })
+	return paths
+}
+
+func TestGetChangedPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []reformav1beta1.RevertPath
+	}{
+		{
+			name:   "unchanged object",
+			before: `{"metadata":{"name":"a"}}`,
+			after:  `{"metadata":{"name":"a"}}`,
+			want:   nil,
+		},
+		{
+			name:   "changed field keeps its previous value",
+			before: `{"data":{"key":"old"}}`,
+			after:  `{"data":{"key":"new"}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/data/key", Value: revertValue(`"old"`)}},
+		},
+		{
+			name:   "added field has no value",
+			before: `{"data":{"key":"old"}}`,
+			after:  `{"data":{"key":"old","other":"new"}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/data/other"}},
+		},
+		{
+			name:   "removed field keeps its previous value",
+			before: `{"data":{"key":"old"}}`,
+			after:  `{"data":{}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/data/key", Value: revertValue(`"old"`)}},
+		},
+		{
+			name:   "created map records only its leaves",
+			before: `{"metadata":{"name":"a"}}`,
+			after:  `{"metadata":{"name":"a","annotations":{"a":"1"}}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/metadata/annotations/a"}},
+		},
+		{
+			name:   "created nested maps record only their leaves",
+			before: `{}`,
+			after:  `{"spec":{"template":{"metadata":{"labels":{"a":"1","b":"2"}}}}}`,
+			want: []reformav1beta1.RevertPath{
+				{Path: "/spec/template/metadata/labels/a"},
+				{Path: "/spec/template/metadata/labels/b"},
+			},
+		},
+		{
+			name:   "scalar replaced by a map keeps the scalar",
+			before: `{"spec":{"value":"old"}}`,
+			after:  `{"spec":{"value":{"key":"new"}}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/spec/value", Value: revertValue(`"old"`)}},
+		},
+		{
+			name:   "added scalar items are recorded by value",
+			before: `{"spec":{"items":["a"]}}`,
+			after:  `{"spec":{"items":["a","b"]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: `/spec/items/v:"b"`}},
+		},
+		{
+			name:   "removed scalar items keep their value",
+			before: `{"spec":{"items":["a","b"]}}`,
+			after:  `{"spec":{"items":["a"]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: `/spec/items/v:"b"`, Value: revertValue(`"b"`)}},
+		},
+		{
+			name:   "items with name are recorded by name",
+			before: `{"spec":{"containers":[{"name":"a","image":"old"},{"name":"c"}]}}`,
+			after:  `{"spec":{"containers":[{"name":"a","image":"new"},{"name":"b"}]}}`,
+			want: []reformav1beta1.RevertPath{
+				{Path: `/spec/containers/k:{"name":"a"}/image`, Value: revertValue(`"old"`)},
+				{Path: `/spec/containers/k:{"name":"b"}`},
+				{Path: `/spec/containers/k:{"name":"c"}`, Value: revertValue(`{"name":"c"}`)},
+			},
+		},
+		{
+			name:   "created lists record only their items",
+			before: `{"spec":{}}`,
+			after:  `{"spec":{"items":["a"]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: `/spec/items/v:"a"`}},
+		},
+		{
+			name:   "lists of objects without name are compared as a whole",
+			before: `{"spec":{"items":[{"value":"a"}]}}`,
+			after:  `{"spec":{"items":[{"value":"b"}]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/spec/items", Value: revertValue(`[{"value":"a"}]`)}},
+		},
+		{
+			name:   "lists with repeated items are compared as a whole",
+			before: `{"spec":{"items":["a","a"]}}`,
+			after:  `{"spec":{"items":["a"]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/spec/items", Value: revertValue(`["a","a"]`)}},
+		},
+		{
+			name:   "keys are escaped as JSON pointers",
+			before: `{"metadata":{"annotations":{}}}`,
+			after:  `{"metadata":{"annotations":{"example.com/a~b":"1"}}}`,
+			want:   []reformav1beta1.RevertPath{{Path: "/metadata/annotations/example.com~1a~0b"}},
+		},
+		{
+			name:   "fields changed by Kubernetes are ignored",
+			before: `{"metadata":{"resourceVersion":"1","generation":1},"status":{"ready":false}}`,
+			after:  `{"metadata":{"resourceVersion":"2","generation":2},"status":{"ready":true}}`,
+			want:   nil,
+		},
+	}
+
+	r := &PatchReconciler{}
+	unknownKind := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Unknown"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := getSortedChangedPaths(t, r, unknownKind, test.before, test.after)
+			if !reflect.DeepEqual(paths, test.want) {
+				t.Errorf("got %+v, want %+v", paths, test.want)
+			}
+		})
+	}
+}
+
+func TestGetChangedPathsOfTypedKinds(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   []reformav1beta1.RevertPath
+	}{
+		{
+			name:   "items are recorded by their merge key",
+			before: `{"spec":{"containers":[{"name":"app","ports":[{"containerPort":80}]}]}}`,
+			after:  `{"spec":{"containers":[{"name":"app","ports":[{"containerPort":80},{"containerPort":443}]}]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: `/spec/containers/k:{"name":"app"}/ports/k:{"containerPort":443}`}},
+		},
+		{
+			name:   "merged scalar lists are recorded by value",
+			before: `{"metadata":{"finalizers":["a"]}}`,
+			after:  `{"metadata":{"finalizers":["a","b"]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: `/metadata/finalizers/v:"b"`}},
+		},
+		{
+			name:   "atomic lists are compared as a whole",
+			before: `{"spec":{"containers":[{"name":"app","args":["a"]}]}}`,
+			after:  `{"spec":{"containers":[{"name":"app","args":["a","b"]}]}}`,
+			want:   []reformav1beta1.RevertPath{{Path: `/spec/containers/k:{"name":"app"}/args`, Value: revertValue(`["a"]`)}},
+		},
+	}
+
+	r := &PatchReconciler{Scheme: newTestScheme(t)}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths := getSortedChangedPaths(t, r, corev1.SchemeGroupVersion.WithKind("Pod"), test.before, test.after)
+			if !reflect.DeepEqual(paths, test.want) {
+				t.Errorf("got %+v, want %+v", paths, test.want)
+			}
+		})
+	}
+}
+
+func TestRestoreRevertPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		paths   []reformav1beta1.RevertPath
+		want    string
+	}{
+		{
+			name:    "previous value is restored",
+			current: `{"data":{"key":"new"}}`,
+			paths:   []reformav1beta1.RevertPath{{Path: "/data/key", Value: revertValue(`"old"`)}},
+			want:    `{"data":{"key":"old"}}`,
+		},
+		{
+			name:    "added fields are removed without touching their siblings",
+			current: `{"metadata":{"annotations":{"a":"1","other":"2"},"labels":{"b":"1"}}}`,
+			paths: []reformav1beta1.RevertPath{
+				{Path: "/metadata/annotations/a"},
+				{Path: "/metadata/labels/b"},
+			},
+			want: `{"metadata":{"annotations":{"other":"2"},"labels":{}}}`,
+		},
+		{
+			name:    "escaped keys are restored",
+			current: `{"metadata":{"annotations":{"example.com/a~b":"1"}}}`,
+			paths:   []reformav1beta1.RevertPath{{Path: "/metadata/annotations/example.com~1a~0b"}},
+			want:    `{"metadata":{"annotations":{}}}`,
+		},
+		{
+			name:    "added items are removed without touching the ones added by others",
+			current: `{"spec":{"containers":[{"name":"a"},{"name":"b"},{"name":"others"}]}}`,
+			paths:   []reformav1beta1.RevertPath{{Path: `/spec/containers/k:{"name":"b"}`}},
+			want:    `{"spec":{"containers":[{"name":"a"},{"name":"others"}]}}`,
+		},
+		{
+			name:    "removed items are added again",
+			current: `{"spec":{"containers":[{"name":"a"}]}}`,
+			paths:   []reformav1beta1.RevertPath{{Path: `/spec/containers/k:{"name":"b"}`, Value: revertValue(`{"name":"b","image":"old"}`)}},
+			want:    `{"spec":{"containers":[{"name":"a"},{"name":"b","image":"old"}]}}`,
+		},
+		{
+			name:    "fields of items are restored in place",
+			current: `{"spec":{"containers":[{"name":"a","image":"new"},{"name":"b","image":"new"}]}}`,
+			paths:   []reformav1beta1.RevertPath{{Path: `/spec/containers/k:{"name":"a"}/image`, Value: revertValue(`"old"`)}},
+			want:    `{"spec":{"containers":[{"name":"a","image":"old"},{"name":"b","image":"new"}]}}`,
+		},
+		{
+			name:    "fields of items removed by others are not restored",
+			current: `{"spec":{"containers":[{"name":"b"}]}}`,
+			paths:   []reformav1beta1.RevertPath{{Path: `/spec/containers/k:{"name":"a"}/image`, Value: revertValue(`"old"`)}},
+			want:    `{"spec":{"containers":[{"name":"b"}]}}`,
+		},
+		{
+			name:    "scalar items are restored by value",
+			current: `{"metadata":{"finalizers":["a","b","others"]}}`,
+			paths: []reformav1beta1.RevertPath{
+				{Path: `/metadata/finalizers/v:"b"`},
+				{Path: `/metadata/finalizers/v:"c"`, Value: revertValue(`"c"`)},
+			},
+			want: `{"metadata":{"finalizers":["a","others","c"]}}`,
+		},
+		{
+			name:    "items with numeric keys are matched",
+			current: `{"ports":[{"containerPort":80},{"containerPort":443}]}`,
+			paths:   []reformav1beta1.RevertPath{{Path: `/ports/k:{"containerPort":443}`}},
+			want:    `{"ports":[{"containerPort":80}]}`,
+		},
+		{
+			name:    "removed parents are created again",
+			current: `{"metadata":{}}`,
+			paths:   []reformav1beta1.RevertPath{{Path: `/metadata/finalizers/v:"a"`, Value: revertValue(`"a"`)}},
+			want:    `{"metadata":{"finalizers":["a"]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			object := decodeObject(t, test.current)
+			if err := restoreRevertPaths(object, test.paths); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(object, decodeObject(t, test.want)) {
+				rawObject, _ := json.Marshal(object)
+				t.Errorf("got %s, want %s", rawObject, test.want)
+			}
+		})
+	}
+}
+
+func TestRestoreChangedPaths(t *testing.T) {
+	before := decodeObject(t, `{"metadata":{"name":"a"},"spec":{"containers":[{"name":"app","image":"old"}]}}`)
+	after := decodeObject(t, `{"metadata":{"name":"a","annotations":{"a":"1"}},`+
+		`"spec":{"containers":[{"name":"app","image":"new"},{"name":"sidecar"}]}}`)
+
+	var paths []reformav1beta1.RevertPath
+	if err := getChangedPaths(before, after, nil, "", &paths); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Annotations and containers added by others after the patch must survive the revert
+	current := decodeObject(t, `{"metadata":{"name":"a","annotations":{"a":"1","others":"1"}},`+
+		`"spec":{"containers":[{"name":"app","image":"new"},{"name":"sidecar"},{"name":"others"}]}}`)
+	if err := restoreRevertPaths(current, paths); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := decodeObject(t, `{"metadata":{"name":"a","annotations":{"others":"1"}},`+
+		`"spec":{"containers":[{"name":"app","image":"old"},{"name":"others"}]}}`)
+	if !reflect.DeepEqual(current, want) {
+		t.Errorf("got %v, want %v", current, want)
+	}
+}
+
+func TestRecordRevertPathsSkipsSecretValues(t *testing.T) {
+	patchManifest := newValidPatch()
+	patchManifest.Spec.DeletionPolicy = reformav1beta1.DeletionPolicyRevert
+
+	newSecret := func(raw string) *unstructured.Unstructured {
+		secret := &unstructured.Unstructured{Object: decodeObject(t, raw)}
+		secret.SetAPIVersion("v1")
+		secret.SetKind("Secret")
+		secret.SetNamespace("default")
+		secret.SetName("secret")
+		return secret
+	}
+
+	before := newSecret(`{"data":{"changed":"b2xk","removed":"b2xk"},"metadata":{"labels":{"a":"old"}}}`)
+	after := newSecret(`{"data":{"changed":"bmV3","added":"bmV3"},"stringData":{"added":"new"},"metadata":{"labels":{"a":"new"}}}`)
+
+	r := &PatchReconciler{Scheme: newTestScheme(t)}
+	if _, err := r.RecordRevertPaths(patchManifest, before, after); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []reformav1beta1.RevertPath{
+		{Path: "/data/added"},
+		{Path: "/metadata/labels/a", Value: revertValue(`"old"`)},
+		{Path: "/stringData/added"},
+	}
+	if got := patchManifest.Status.Reverts[0].Paths; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestPersistRevertStatus(t *testing.T) {
+	patchManifest := newValidPatch()
+	scheme := newTestScheme(t)
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(patchManifest.DeepCopy()).
+		WithStatusSubresource(&reformav1beta1.Patch{}).
+		Build()
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(patchManifest), patchManifest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reverts := []reformav1beta1.TargetRevertStatus{{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Namespace:  "default",
+		Name:       "target",
+		Paths:      []reformav1beta1.RevertPath{{Path: "/data/key", Value: revertValue(`"old"`)}},
+	}}
+	patchManifest.Status.Reverts = reverts
+	patchManifest.Status.LastAppliedHash = "not persisted"
+
+	r := &PatchReconciler{Client: c, Scheme: scheme}
+	if err := r.persistRevertStatus(context.Background(), patchManifest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	persistedPatch := &reformav1beta1.Patch{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(patchManifest), persistedPatch); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(persistedPatch.Status.Reverts, reverts) {
+		t.Errorf("got reverts %+v, want %+v", persistedPatch.Status.Reverts, reverts)
+	}
+	if persistedPatch.Status.LastAppliedHash != "" {
+		t.Errorf("only the reverts must be persisted, got %+v", persistedPatch.Status)
+	}
+
+	// The Patch can still be updated at the end of the synchronization
+	if err := c.Status().Update(context.Background(), patchManifest); err != nil {
+		t.Errorf("unexpected error updating the status afterwards: %v", err)
+	}
+}
+
+func TestRevertTargets(t *testing.T) {
+	target := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "target", Labels: map[string]string{"others": "1"}},
+		Data:       map[string]string{"key": "new", "added": "new", "others": "new"},
+	}
+	reverts := []reformav1beta1.TargetRevertStatus{
+		{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Namespace:  "default",
+			Name:       "target",
+			Paths: []reformav1beta1.RevertPath{
+				{Path: "/data/added"},
+				{Path: "/data/key", Value: revertValue(`"old"`)},
+			},
+		},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "deleted", Paths: []reformav1beta1.RevertPath{}},
+	}
+
+	forbidden := interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			return apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, key.Name, nil)
+		},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		funcs       interceptor.Funcs
+		wantErr     bool
+		wantReason  string
+		wantData    map[string]string
+	}{
+		{
+			name:     "targets are restored and missing ones ignored",
+			wantData: map[string]string{"key": "old", "others": "new"},
+		},
+		{
+			name:       "forbidden targets keep the Patch",
+			funcs:      forbidden,
+			wantErr:    true,
+			wantReason: ConditionReasonRevertForbidden,
+		},
+		{
+			name:        "forbidden targets are abandoned when annotated",
+			annotations: map[string]string{abandonRevertAnnotation: "true"},
+			funcs:       forbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := newTestScheme(t)
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(target.DeepCopy()).
+				WithInterceptorFuncs(test.funcs).
+				Build()
+
+			patchManifest := newValidPatch()
+			patchManifest.Annotations = test.annotations
+			patchManifest.Spec.DeletionPolicy = reformav1beta1.DeletionPolicyRevert
+			patchManifest.Status.Reverts = reverts
+
+			r := &PatchReconciler{Client: c, Scheme: scheme, Recorder: record.NewFakeRecorder(10)}
+			err := r.RevertTargets(context.Background(), patchManifest)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched)
+			if test.wantErr && (condition == nil || condition.Reason != test.wantReason) {
+				t.Errorf("got condition %+v, want reason %s", condition, test.wantReason)
+			}
+
+			// Forbidden targets can not be read back, and are left as they are anyway
+			if test.funcs.Get != nil {
+				return
+			}
+
+			revertedTarget := &corev1.ConfigMap{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(target), revertedTarget); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(revertedTarget.Data, test.wantData) {
+				t.Errorf("got data %v, want %v", revertedTarget.Data, test.wantData)
+			}
+			if revertedTarget.Labels["others"] != "1" {
+				t.Errorf("fields not recorded must be kept, got labels %v", revertedTarget.Labels)
+			}
+		})
+	}
+}
@@ -1,7 +1,13 @@
 package controller
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
 )
 
@@ -18,6 +24,27 @@ const (
 	ConditionReasonTargetNotFound        = "TargetNotFound"
 	ConditionReasonTargetNotFoundMessage = "Target resource was not found"
 
+	// Reference not permitted
+	ConditionReasonReferenceNotPermitted        = "ReferenceNotPermitted"
+	ConditionReasonReferenceNotPermittedMessage = "Patch references objects outside its namespace not granted by any ReferenceGrant"
+
+	// Invalid ServiceAccount
+	ConditionReasonInvalidServiceAccount        = "InvalidServiceAccount"
+	ConditionReasonInvalidServiceAccountMessage = "ServiceAccount to impersonate is not fully defined"
+
+	// Forbidden
+	ConditionReasonForbidden        = "Forbidden"
+	ConditionReasonForbiddenMessage = "ServiceAccount is not allowed to perform the action: %s"
+
+	// Invalid target selector
+	ConditionReasonInvalidTargetSelector        = "InvalidTargetSelector"
+	ConditionReasonInvalidTargetSelectorMessage = "Targets can not be selected with the given selector"
+
+	// Too many targets selected
+	ConditionReasonTooManyTargets        = "TooManyTargets"
+	ConditionReasonTooManyTargetsMessage = "Target selector selects %d targets, but %d at most are allowed. " +
+		"Narrow the selector or split the Patch"
+
 	// Invalid patch type
 	ConditionReasonInvalidPatchType        = "InvalidPatchType"
 	ConditionReasonInvalidPatchTypeMessage = "Patch type is not supported"
@@ -26,24 +53,80 @@ const (
 	ConditionReasonInvalidTemplate        = "InvalidTemplate"
 	ConditionReasonInvalidTemplateMessage = "Patch template is not valid. Deeper information inside the Patch status"
 
+	// Invalid replacements
+	ConditionReasonInvalidReplacements        = "InvalidReplacements"
+	ConditionReasonInvalidReplacementsMessage = "Replacements can not be computed: %s"
+
+	// Invalid when expression
+	ConditionReasonInvalidWhen        = "InvalidWhen"
+	ConditionReasonInvalidWhenMessage = "When expression can not be evaluated: %s"
+
 	// Failure
 	ConditionReasonInvalidPatch        = "InvalidPatch"
 	ConditionReasonInvalidPatchMessage = "Patch is invalid"
 
+	// Target not created
+	ConditionReasonTargetNotCreated        = "TargetNotCreated"
+	ConditionReasonTargetNotCreatedMessage = "Target was not found and could not be created from the template"
+
+	// Apply conflict
+	ConditionReasonApplyConflict        = "ApplyConflict"
+	ConditionReasonApplyConflictMessage = "Applied fields are owned by other managers. Set 'serverSideApply.force' to take them: %s"
+
+	// Fields release failed
+	ConditionReasonFieldsReleaseFailed        = "FieldsReleaseFailed"
+	ConditionReasonFieldsReleaseFailedMessage = "Fields owned on the targets could not be released"
+
+	// Revert failed
+	ConditionReasonRevertFailed        = "RevertFailed"
+	ConditionReasonRevertFailedMessage = "Targets could not be reverted. Set 'deletionPolicy' to Retain to delete the Patch anyway"
+
+	// Revert forbidden
+	ConditionReasonRevertForbidden        = "RevertForbidden"
+	ConditionReasonRevertForbiddenMessage = "Patch is not allowed to restore some targets anymore. Grant the permissions back, " +
+		"or annotate the Patch with '%s: \"true\"' to delete it leaving them as they are"
+
+	// Revert not recorded
+	ConditionReasonRevertNotRecorded        = "RevertNotRecorded"
+	ConditionReasonRevertNotRecordedMessage = "Previous values of the target could not be recorded before patching it: %s"
+
+	// Revert abandoned
+	ConditionReasonRevertAbandoned        = "RevertAbandoned"
+	ConditionReasonRevertAbandonedMessage = "Target was left as it is, as the Patch is not allowed to restore it anymore: %s"
+
+	// Skipped
+	ConditionReasonSkipped        = "Skipped"
+	ConditionReasonSkippedMessage = "Target was skipped as the when expression is false"
+
+	// Unchanged
+	ConditionReasonTargetUnchanged        = "TargetUnchanged"
+	ConditionReasonTargetUnchangedMessage = "Target is already patched, so it was not patched again"
+
 	// Success
 	ConditionReasonTargetPatched        = "TargetPatched"
 	ConditionReasonTargetPatchedMessage = "Target was successfully patched"
 
+	// ConditionTypeDriftDetected indicates that the patched fields were changed by others since the previous synchronization
+	ConditionTypeDriftDetected = "DriftDetected"
+
+	// Drift detected
+	ConditionReasonTargetDrifted        = "TargetDrifted"
+	ConditionReasonTargetDriftedMessage = "Patched fields were changed by others on some targets, so they were patched again"
+
+	// No drift
+	ConditionReasonNoDrift        = "NoDrift"
+	ConditionReasonNoDriftMessage = "Patched fields were not changed by others"
+
 	// ConditionTypeTemplateSucceed indicates that the templating stage was performed successfully
 	ConditionTypeTemplateSucceed = "TemplateSucceed"
 
 	// Template parsing failed
 	ConditionReasonTemplateParsingFailed        = "TemplateParsingFailed"
-	ConditionReasonTemplateParsingFailedMessage = "Golang returned: %s"
+	ConditionReasonTemplateParsingFailedMessage = "%s returned: %s"
 
 	// Template execution failed
 	ConditionReasonTemplateExecutionFailed        = "TemplateExecutionFailed"
-	ConditionReasonTemplateExecutionFailedMessage = "Golang returned: %s"
+	ConditionReasonTemplateExecutionFailedMessage = "%s returned: %s"
 
 	// Success
 	ConditionReasonTemplateParsed        = "TemplateParsed"
@@ -62,30 +145,120 @@ func (r *PatchReconciler) NewPatchCondition(condType string, status metav1.Condi
 }
 
 // GetPatchCondition returns the condition with the provided type.
-func (r *PatchReconciler) GetPatchCondition(patch *reformav1beta1.Patch, condType string) *metav1.Condition {
+func (r *PatchReconciler) GetPatchCondition(patch reformav1beta1.PatchObject, condType string) *metav1.Condition {
 
-	for i, v := range patch.Status.Conditions {
+	for i, v := range patch.GetStatus().Conditions {
 		if v.Type == condType {
-			return &patch.Status.Conditions[i]
+			return &patch.GetStatus().Conditions[i]
 		}
 	}
 	return nil
 }
 
 // UpdatePatchCondition update or create a new condition inside the status of the CR
-func (r *PatchReconciler) UpdatePatchCondition(patch *reformav1beta1.Patch, condition *metav1.Condition) {
+func (r *PatchReconciler) UpdatePatchCondition(patch reformav1beta1.PatchObject, condition *metav1.Condition) {
 
 	// Get the condition
 	currentCondition := r.GetPatchCondition(patch, condition.Type)
 
+	// Conditions are observed on the current generation of the Patch
+	condition.ObservedGeneration = patch.GetGeneration()
+
 	if currentCondition == nil {
 		// Create the condition when not existent
-		patch.Status.Conditions = append(patch.Status.Conditions, *condition)
+		patch.GetStatus().Conditions = append(patch.GetStatus().Conditions, *condition)
 	} else {
-		// Update the condition when existent.
+		// Update the condition when existent. The transition time only changes with the status
+		if currentCondition.Status != condition.Status {
+			currentCondition.LastTransitionTime = condition.LastTransitionTime
+		}
 		currentCondition.Status = condition.Status
 		currentCondition.Reason = condition.Reason
 		currentCondition.Message = condition.Message
-		currentCondition.LastTransitionTime = metav1.Now()
+		currentCondition.ObservedGeneration = condition.ObservedGeneration
 	}
 }
+
+// UpdateSyncStatus record inside the status of the CR the result of a synchronization
+func (r *PatchReconciler) UpdateSyncStatus(patchManifest reformav1beta1.PatchObject, err error) {
+	status := patchManifest.GetStatus()
+	now := metav1.Now()
+
+	status.ObservedGeneration = patchManifest.GetGeneration()
+	status.LastSyncTime = &now
+
+	if err != nil {
+		status.ConsecutiveFailures++
+		return
+	}
+
+	status.LastSuccessfulSyncTime = &now
+	status.ConsecutiveFailures = 0
+	status.LastAppliedHash = getAppliedHash(status.Targets)
+}
+
+// getAppliedHash return a hash of the patches applied on all the targets
+func getAppliedHash(targets []reformav1beta1.TargetStatus) string {
+	var patchHashes [][]byte
+	for _, targetStatus := range targets {
+		if targetStatus.PatchHash != "" {
+			patchHashes = append(patchHashes, []byte(targetStatus.PatchHash))
+		}
+	}
+
+	if len(patchHashes) == 0 {
+		return ""
+	}
+	return getPatchesHash(patchHashes)
+}
+
+// setSourceStatus set the status of a source, replacing the one recorded for the same source
+func setSourceStatus(status *reformav1beta1.PatchStatus, sourceStatus reformav1beta1.SourceStatus) {
+	for i, recorded := range status.Sources {
+		if recorded.APIVersion == sourceStatus.APIVersion && recorded.Kind == sourceStatus.Kind &&
+			recorded.Namespace == sourceStatus.Namespace && recorded.Name == sourceStatus.Name &&
+			recorded.LabelSelector == sourceStatus.LabelSelector && recorded.FieldSelector == sourceStatus.FieldSelector {
+			status.Sources[i] = sourceStatus
+			return
+		}
+	}
+
+	status.Sources = append(status.Sources, sourceStatus)
+}
+
+// recordSourceVersion record inside the status of the CR the version of an object used as source
+func (r *PatchReconciler) recordSourceVersion(patchManifest reformav1beta1.PatchObject, source *unstructured.Unstructured) {
+	setSourceStatus(patchManifest.GetStatus(), reformav1beta1.SourceStatus{
+		APIVersion:      source.GetAPIVersion(),
+		Kind:            source.GetKind(),
+		Namespace:       source.GetNamespace(),
+		Name:            source.GetName(),
+		ResourceVersion: source.GetResourceVersion(),
+	})
+}
+
+// recordSourceListVersion record inside the status of the CR the versions of the objects selected by a source,
+// as a single digest, so sources selecting many objects do not grow the status without limit
+func (r *PatchReconciler) recordSourceListVersion(patchManifest reformav1beta1.PatchObject, source reformav1beta1.SourceSpec,
+	namespace string, labelSelector string, sourceObjects []*unstructured.Unstructured) {
+
+	versions := make([]string, 0, len(sourceObjects))
+	for _, sourceObject := range sourceObjects {
+		versions = append(versions, strings.Join([]string{
+			sourceObject.GetNamespace(), sourceObject.GetName(), sourceObject.GetResourceVersion(),
+		}, "/"))
+	}
+	sort.Strings(versions)
+
+	digest := sha256.Sum256([]byte(strings.Join(versions, "\n")))
+
+	setSourceStatus(patchManifest.GetStatus(), reformav1beta1.SourceStatus{
+		APIVersion:    source.APIVersion,
+		Kind:          source.Kind,
+		Namespace:     namespace,
+		LabelSelector: labelSelector,
+		FieldSelector: source.Selector.FieldSelector,
+		Selected:      len(sourceObjects),
+		Digest:        hex.EncodeToString(digest[:]),
+	})
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"strconv"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// GetPatchSteps return the steps to perform against each target, in order.
+// Patches with replacements have a single JSON patch step computing them, while patches without steps
+// have a single step made from their template and patch type
+func GetPatchSteps(patchManifest reformav1beta1.PatchObject) (steps []reformav1beta1.PatchStepSpec) {
+	if len(patchManifest.GetSpec().Replacements) > 0 {
+		return append(steps, reformav1beta1.PatchStepSpec{
+			PatchType: types.JSONPatchType,
+		})
+	}
+
+	if len(patchManifest.GetSpec().Steps) > 0 {
+		return patchManifest.GetSpec().Steps
+	}
+
+	return append(steps, reformav1beta1.PatchStepSpec{
+		Template:  patchManifest.GetSpec().Template,
+		PatchType: patchManifest.GetSpec().PatchType,
+	})
+}
+
+// getStepName return the name of a step, or its position when it has no name
+func getStepName(step reformav1beta1.PatchStepSpec, index int) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return strconv.Itoa(index)
+}
+
+// getFailedStepName return the name of a failing step to report it, only when the Patch is defined by steps
+func getFailedStepName(patchManifest reformav1beta1.PatchObject, step reformav1beta1.PatchStepSpec, index int) string {
+	if len(patchManifest.GetSpec().Steps) == 0 || len(patchManifest.GetSpec().Replacements) > 0 {
+		return ""
+	}
+	return getStepName(step, index)
+}
+
+// getStepFieldManager return the field manager applying the fields of a step.
+// Each step of a Patch defined by steps has its own, so they do not remove the fields applied by the others
+func getStepFieldManager(patchManifest reformav1beta1.PatchObject, step reformav1beta1.PatchStepSpec, index int) (fieldManager string) {
+	fieldManager = GetFieldManager(patchManifest)
+	if len(patchManifest.GetSpec().Steps) == 0 {
+		return fieldManager
+	}
+
+	stepSuffix := "/" + getStepName(step, index)
+	if len(fieldManager)+len(stepSuffix) > fieldManagerMaxLength {
+		fieldManager = fieldManager[:fieldManagerMaxLength-len(stepSuffix)]
+	}
+
+	return fieldManager + stepSuffix
+}
+
+// getApplyFieldManagers return the field managers of all the steps performed using server-side apply
+func getApplyFieldManagers(patchManifest reformav1beta1.PatchObject) (fieldManagers []string) {
+	for i, step := range GetPatchSteps(patchManifest) {
+		if step.PatchType == types.ApplyPatchType {
+			fieldManagers = append(fieldManagers, getStepFieldManager(patchManifest, step, i))
+		}
+	}
+	return fieldManagers
+}
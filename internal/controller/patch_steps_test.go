@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGetPatchSteps(t *testing.T) {
+	steps := []reformav1beta1.PatchStepSpec{
+		{Name: "first", Template: "a", PatchType: types.JSONPatchType},
+		{Template: "b", PatchType: types.ApplyPatchType},
+	}
+
+	tests := []struct {
+		name string
+		spec reformav1beta1.PatchSpec
+		want []reformav1beta1.PatchStepSpec
+	}{
+		{
+			name: "template is a single step",
+			spec: reformav1beta1.PatchSpec{Template: "a", PatchType: types.MergePatchType},
+			want: []reformav1beta1.PatchStepSpec{{Template: "a", PatchType: types.MergePatchType}},
+		},
+		{
+			name: "steps are kept in order",
+			spec: reformav1beta1.PatchSpec{Steps: steps},
+			want: steps,
+		},
+		{
+			name: "replacements are a single JSON patch step",
+			spec: reformav1beta1.PatchSpec{
+				Steps:        steps,
+				Replacements: []reformav1beta1.ReplacementSpec{{}},
+			},
+			want: []reformav1beta1.PatchStepSpec{{PatchType: types.JSONPatchType}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := GetPatchSteps(&reformav1beta1.Patch{Spec: test.spec})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetFailedStepName(t *testing.T) {
+	named := reformav1beta1.PatchStepSpec{Name: "named"}
+	unnamed := reformav1beta1.PatchStepSpec{}
+
+	tests := []struct {
+		name  string
+		spec  reformav1beta1.PatchSpec
+		step  reformav1beta1.PatchStepSpec
+		index int
+		want  string
+	}{
+		{
+			name: "Patches without steps do not report them",
+			spec: reformav1beta1.PatchSpec{Template: "a"},
+			step: named,
+			want: "",
+		},
+		{
+			name:  "named steps are reported by name",
+			spec:  reformav1beta1.PatchSpec{Steps: []reformav1beta1.PatchStepSpec{unnamed, named}},
+			step:  named,
+			index: 1,
+			want:  "named",
+		},
+		{
+			name:  "unnamed steps are reported by position",
+			spec:  reformav1beta1.PatchSpec{Steps: []reformav1beta1.PatchStepSpec{unnamed, named}},
+			step:  unnamed,
+			index: 0,
+			want:  "0",
+		},
+		{
+			name: "replacements do not report steps",
+			spec: reformav1beta1.PatchSpec{
+				Steps:        []reformav1beta1.PatchStepSpec{named},
+				Replacements: []reformav1beta1.ReplacementSpec{{}},
+			},
+			step: named,
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := getFailedStepName(&reformav1beta1.Patch{Spec: test.spec}, test.step, test.index)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetApplyFieldManagers(t *testing.T) {
+	objectMeta := metav1.ObjectMeta{Namespace: "default", Name: "patch"}
+	longObjectMeta := metav1.ObjectMeta{Namespace: "default", Name: strings.Repeat("a", 200)}
+
+	tests := []struct {
+		name       string
+		objectMeta metav1.ObjectMeta
+		spec       reformav1beta1.PatchSpec
+		want       []string
+	}{
+		{
+			name:       "applied template uses the field manager of the Patch",
+			objectMeta: objectMeta,
+			spec:       reformav1beta1.PatchSpec{Template: "a", PatchType: types.ApplyPatchType},
+			want:       []string{"reforma/default/patch"},
+		},
+		{
+			name:       "template not applied has no field manager",
+			objectMeta: objectMeta,
+			spec:       reformav1beta1.PatchSpec{Template: "a", PatchType: types.MergePatchType},
+			want:       nil,
+		},
+		{
+			name:       "applied steps have their own field manager",
+			objectMeta: objectMeta,
+			spec: reformav1beta1.PatchSpec{Steps: []reformav1beta1.PatchStepSpec{
+				{Name: "first", PatchType: types.ApplyPatchType},
+				{Name: "merged", PatchType: types.MergePatchType},
+				{PatchType: types.ApplyPatchType},
+			}},
+			want: []string{"reforma/default/patch/first", "reforma/default/patch/2"},
+		},
+		{
+			name:       "long field managers keep the name of the step",
+			objectMeta: longObjectMeta,
+			spec: reformav1beta1.PatchSpec{Steps: []reformav1beta1.PatchStepSpec{
+				{Name: "first", PatchType: types.ApplyPatchType},
+			}},
+			want: []string{("reforma/default/" + longObjectMeta.Name)[:fieldManagerMaxLength-len("/first")] + "/first"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := getApplyFieldManagers(&reformav1beta1.Patch{ObjectMeta: test.objectMeta, Spec: test.spec})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			for _, fieldManager := range got {
+				if len(fieldManager) > fieldManagerMaxLength {
+					t.Errorf("got a field manager of %d characters, want %d at most", len(fieldManager), fieldManagerMaxLength)
+				}
+			}
+		})
+	}
+}
@@ -2,17 +2,23 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 
-	"bytes"
+	"slices"
 	"strings"
-	"text/template"
 	"time"
 
 	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
 
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
@@ -45,34 +51,127 @@ func GetPatchTypesString() (types []string) {
 }
 
 // GetSynchronizationTime return the spec.synchronization.time as duration, or default time on failures
-func (r *PatchReconciler) GetSynchronizationTime(patchManifest *reformav1beta1.Patch) (synchronizationTime time.Duration, err error) {
-	synchronizationTime, err = time.ParseDuration(patchManifest.Spec.Synchronization.Time)
+func (r *PatchReconciler) GetSynchronizationTime(patchManifest reformav1beta1.PatchObject) (synchronizationTime time.Duration, err error) {
+	synchronizationTime, err = time.ParseDuration(patchManifest.GetSpec().Synchronization.Time)
 	if err != nil {
-		err = NewErrorf(parseSyncTimeError, patchManifest.Name)
+		err = NewErrorf(parseSyncTimeError, patchManifest.GetName())
 		return synchronizationTime, err
 	}
 
 	return synchronizationTime, err
 }
 
-// addSources fill the resources list from input parameters with the content of the sources
-func (r *PatchReconciler) addSources(ctx context.Context, patchManifest *reformav1beta1.Patch, resources *[]map[string]interface{}) (err error) {
+// getSourceList return the content of all the objects selected by a source.
+// Objects that the Patch is not permitted to reference are excluded
+func (r *PatchReconciler) getSourceList(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject,
+	source reformav1beta1.SourceSpec) (objects []interface{}, err error) {
+
+	objects = []interface{}{}
+
+	listOptions := []client.ListOption{}
+
+	selector, err := getSelector(source.Selector.LabelSelector)
+	if err != nil {
+		return objects, err
+	}
+	listOptions = append(listOptions, client.MatchingLabelsSelector{Selector: selector})
+
+	if source.Selector.FieldSelector != "" {
+		fieldSelector, err := fields.ParseSelector(source.Selector.FieldSelector)
+		if err != nil {
+			return objects, err
+		}
+		listOptions = append(listOptions, client.MatchingFieldsSelector{Selector: fieldSelector})
+	}
+
+	namespace := ""
+	if !source.Selector.AllNamespaces {
+		namespace = getReferenceNamespace(patchManifest, source.ObjectReference)
+		listOptions = append(listOptions, client.InNamespace(namespace))
+	}
+
+	sourceList := &unstructured.UnstructuredList{}
+	sourceList.SetAPIVersion(source.APIVersion)
+	sourceList.SetKind(source.Kind + "List")
+
+	err = patchClient.List(ctx, sourceList, listOptions...)
+	if err != nil {
+		return objects, err
+	}
+
+	var selectedObjects []*unstructured.Unstructured
+	for i, sourceObject := range sourceList.Items {
+		permitted, err := r.isReferencePermitted(ctx, patchManifest, corev1.ObjectReference{
+			APIVersion: source.APIVersion,
+			Kind:       source.Kind,
+			Namespace:  sourceObject.GetNamespace(),
+			Name:       sourceObject.GetName(),
+		}, reformav1beta1.ReferenceUsageSource)
+		if err != nil {
+			return objects, err
+		}
+
+		if permitted {
+			selectedObjects = append(selectedObjects, &sourceList.Items[i])
+			objects = append(objects, sourceObject.Object)
+		}
+	}
+
+	r.recordSourceListVersion(patchManifest, source, namespace, selector.String(), selectedObjects)
+
+	return objects, err
+}
+
+// getSourceDefault return the content given to the template when an optional source is not found
+func getSourceDefault(source reformav1beta1.SourceSpec) (defaultObject interface{}, err error) {
+	if source.Default == nil {
+		return defaultObject, err
+	}
+
+	err = json.Unmarshal(source.Default.Raw, &defaultObject)
+	return defaultObject, err
+}
+
+// addSources fill the resources list from input parameters with the content of the sources.
+// Sources with selector are added as a list of objects
+func (r *PatchReconciler) addSources(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject, resources *[]interface{}) (err error) {
+
+	ctx, span := startSpan(ctx, client.ObjectKeyFromObject(patchManifest), "addSources", getSourcesAttribute(patchManifest))
+	defer func() { endSpan(span, err) }()
 
 	// Fill the sources content, one by one
-	sourceObject := &unstructured.Unstructured{}
+	for _, source := range patchManifest.GetSpec().Sources {
+
+		if source.Selector != nil {
+			sourceObjects, err := r.getSourceList(ctx, patchClient, patchManifest, source)
+			if err != nil {
+				return err
+			}
 
-	for _, sourceReference := range patchManifest.Spec.Sources {
-		sourceObject.SetGroupVersionKind(sourceReference.GroupVersionKind())
+			*resources = append(*resources, sourceObjects)
+			continue
+		}
 
-		err = r.Get(ctx, client.ObjectKey{
-			Namespace: sourceReference.Namespace,
-			Name:      sourceReference.Name,
-		}, sourceObject)
+		sourceObject := &unstructured.Unstructured{}
+		sourceObject.SetGroupVersionKind(source.GroupVersionKind())
 
+		err = patchClient.Get(ctx, getReferenceKey(patchManifest, source.ObjectReference), sourceObject)
 		if err != nil {
-			return err
+			if !source.Optional || !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			// Missing optional sources are replaced by their default
+			defaultObject, err := getSourceDefault(source)
+			if err != nil {
+				return err
+			}
+
+			*resources = append(*resources, defaultObject)
+			continue
 		}
 
+		r.recordSourceVersion(patchManifest, sourceObject)
 		*resources = append(*resources, sourceObject.Object)
 	}
 
@@ -80,18 +179,32 @@ func (r *PatchReconciler) addSources(ctx context.Context, patchManifest *reforma
 }
 
 // addTarget fill the resources list from input parameters with the target object content
-func (r *PatchReconciler) addTarget(ctx context.Context, patchManifest *reformav1beta1.Patch, resources *[]map[string]interface{}) (err error) {
+func (r *PatchReconciler) addTarget(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject,
+	targetReference corev1.ObjectReference, resources *[]interface{}) (err error) {
+
+	ctx, span := startSpan(ctx, client.ObjectKeyFromObject(patchManifest), "addTarget",
+		getReferenceAttributes(patchManifest, targetReference)...)
+	defer func() { endSpan(span, err) }()
+
+	// Targets being admitted do not exist yet, so they are taken from the admission request
+	if admittedTarget, ok := ctx.Value(admittedTargetKey{}).(*unstructured.Unstructured); ok {
+		*resources = append(*resources, admittedTarget.Object)
+		return err
+	}
 
 	// Get the target manifest
 	target := &unstructured.Unstructured{}
-	target.SetGroupVersionKind(patchManifest.Spec.Target.GroupVersionKind())
+	target.SetGroupVersionKind(targetReference.GroupVersionKind())
 
-	err = r.Get(ctx, client.ObjectKey{
-		Namespace: patchManifest.Spec.Target.Namespace,
-		Name:      patchManifest.Spec.Target.Name,
-	}, target)
+	err = patchClient.Get(ctx, getReferenceKey(patchManifest, targetReference), target)
 	if err != nil {
-		return err
+		if !apierrors.IsNotFound(err) || patchManifest.GetSpec().TargetPolicy != reformav1beta1.TargetPolicyCreateIfMissing {
+			return err
+		}
+
+		// Missing targets will be created from the rendered template, so it is rendered with an empty one
+		*resources = append(*resources, map[string]interface{}{})
+		return nil
 	}
 
 	*resources = append(*resources, target.Object)
@@ -99,12 +212,32 @@ func (r *PatchReconciler) addTarget(ctx context.Context, patchManifest *reformav
 	return err
 }
 
-// GetResources return a JSON compatible list of objects with the target and the sources
-func (r *PatchReconciler) GetResources(ctx context.Context, patchManifest *reformav1beta1.Patch) (resources []map[string]interface{}, err error) {
+// GetResources return a JSON compatible list of objects with the target and the sources, in the order they are defined.
+// The target is always the first one, so each render knows which target it is working on
+func (r *PatchReconciler) GetResources(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject,
+	targetReference corev1.ObjectReference) (resources []interface{}, err error) {
+
+	ctx, span := startSpan(ctx, client.ObjectKeyFromObject(patchManifest), "GetResources",
+		getReferenceAttributes(patchManifest, targetReference)...)
+	defer func() { endSpan(span, err) }()
+
+	// Check the Patch is allowed to use the referenced objects
+	err = r.CheckReferences(ctx, patchManifest, targetReference)
+	if err != nil {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonReferenceNotPermitted,
+			ConditionReasonReferenceNotPermittedMessage,
+		))
+		return resources, err
+	}
 
 	// Fill the resources list with the target
-	err = r.addTarget(ctx, patchManifest, &resources)
+	err = r.addTarget(ctx, patchClient, patchManifest, targetReference, &resources)
 	if err != nil {
+		if r.updateForbiddenCondition(patchManifest, err) {
+			return resources, err
+		}
 		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
 			metav1.ConditionFalse,
 			ConditionReasonTargetNotFound,
@@ -114,8 +247,11 @@ func (r *PatchReconciler) GetResources(ctx context.Context, patchManifest *refor
 	}
 
 	// Fill the resources list with the sources
-	err = r.addSources(ctx, patchManifest, &resources)
+	err = r.addSources(ctx, patchClient, patchManifest, &resources)
 	if err != nil {
+		if r.updateForbiddenCondition(patchManifest, err) {
+			return resources, err
+		}
 		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
 			metav1.ConditionFalse,
 			ConditionReasonSourceNotFound,
@@ -126,15 +262,23 @@ func (r *PatchReconciler) GetResources(ctx context.Context, patchManifest *refor
 	return resources, err
 }
 
-// CheckPatchType check if the 'patchType' in the Path CR is available
-func (r *PatchReconciler) CheckPatchType(patchManifest *reformav1beta1.Patch) (err error) {
+// CheckPatchType check if the 'patchType' of every step in the Path CR is available
+func (r *PatchReconciler) CheckPatchType(patchManifest reformav1beta1.PatchObject) (err error) {
 
-	for _, AvailabePatchType := range AvailabePatchTypes {
-		if AvailabePatchType == patchManifest.Spec.PatchType {
-			return err
+	availableSteps := 0
+	for _, step := range GetPatchSteps(patchManifest) {
+		for _, AvailabePatchType := range AvailabePatchTypes {
+			if AvailabePatchType == step.PatchType {
+				availableSteps++
+				break
+			}
 		}
 	}
 
+	if availableSteps == len(GetPatchSteps(patchManifest)) {
+		return err
+	}
+
 	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
 		metav1.ConditionFalse,
 		ConditionReasonInvalidPatchType,
@@ -145,44 +289,61 @@ func (r *PatchReconciler) CheckPatchType(patchManifest *reformav1beta1.Patch) (e
 	return err
 }
 
-// GetPatch return the patch string already prepared to call the Kubernetes API
-func (r *PatchReconciler) GetPatch(ctx context.Context, patchManifest *reformav1beta1.Patch) (parsedPatch string, err error) {
-
-	// Map useful sprig functions to give superpower to the users
-	templateFunctionsMap := r.GetFunctionsMap()
-
-	// Get the resources from a Patch CR
-	resources, err := r.GetResources(ctx, patchManifest)
-	if err != nil {
+// GetPatch return the patch string of a step for a target already prepared to call the Kubernetes API.
+// The resources are the ones returned by GetResources for the target
+func (r *PatchReconciler) GetPatch(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	step reformav1beta1.PatchStepSpec, targetReference corev1.ObjectReference, resources []interface{}) (parsedPatch string, err error) {
+
+	ctx, span := startSpan(ctx, client.ObjectKeyFromObject(patchManifest), "GetPatch",
+		append(getReferenceAttributes(patchManifest, targetReference),
+			attribute.String(spanAttributeEngine, string(getTemplateEngine(patchManifest))),
+			attribute.String(spanAttributeStep, step.Name),
+		)...)
+	defer func() { endSpan(span, err) }()
+
+	// Replacements are computed without templates
+	if len(patchManifest.GetSpec().Replacements) > 0 {
+		parsedPatch, err = r.GetReplacementsPatch(patchManifest, resources)
+		if err != nil {
+			r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+				metav1.ConditionFalse,
+				ConditionReasonInvalidReplacements,
+				fmt.Sprintf(ConditionReasonInvalidReplacementsMessage, err.Error()),
+			))
+		}
 		return parsedPatch, err
 	}
 
-	// Create a Template object from the given string
-	template, err := template.New("main").Funcs(templateFunctionsMap).Parse(patchManifest.Spec.Template)
-	if err != nil {
-		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeTemplateSucceed,
-			metav1.ConditionFalse,
-			ConditionReasonTemplateParsingFailed,
-			fmt.Sprintf(ConditionReasonTemplateParsingFailedMessage, err.Error()),
-		))
-		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
-			metav1.ConditionFalse,
-			ConditionReasonInvalidTemplate,
-			ConditionReasonInvalidTemplateMessage,
-		))
-		return parsedPatch, err
+	// Render the patch with the engine chosen in the Patch
+	engine := getTemplateEngine(patchManifest)
+	renderStart := time.Now()
+	switch engine {
+	case reformav1beta1.TemplateEngineCEL:
+		parsedPatch, err = r.renderCEL(patchManifest, step, resources)
+	case reformav1beta1.TemplateEngineJsonnet:
+		parsedPatch, err = r.renderJsonnet(ctx, patchManifest, step, resources)
+	case reformav1beta1.TemplateEngineJq:
+		parsedPatch, err = r.renderJq(ctx, patchManifest, step, resources)
+	default:
+		parsedPatch, err = r.renderGoTemplate(patchManifest, step, resources)
 	}
+	observeDuration(templateRenderDuration, renderStart, getPatchMetricLabels(patchManifest, string(engine))...)
 
-	// Create a new buffer to store the templating result
-	buffer := new(bytes.Buffer)
-
-	err = template.Execute(buffer, resources)
 	if err != nil {
-		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeTemplateSucceed,
-			metav1.ConditionFalse,
-			ConditionReasonTemplateExecutionFailed,
-			fmt.Sprintf(ConditionReasonTemplateExecutionFailedMessage, err.Error()),
-		))
+		renderErr := &RenderError{}
+		if errors.As(err, &renderErr) && renderErr.Parsing {
+			r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeTemplateSucceed,
+				metav1.ConditionFalse,
+				ConditionReasonTemplateParsingFailed,
+				fmt.Sprintf(ConditionReasonTemplateParsingFailedMessage, templateEngineNames[engine], err.Error()),
+			))
+		} else {
+			r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeTemplateSucceed,
+				metav1.ConditionFalse,
+				ConditionReasonTemplateExecutionFailed,
+				fmt.Sprintf(ConditionReasonTemplateExecutionFailedMessage, templateEngineNames[engine], err.Error()),
+			))
+		}
 		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
 			metav1.ConditionFalse,
 			ConditionReasonInvalidTemplate,
@@ -191,8 +352,6 @@ func (r *PatchReconciler) GetPatch(ctx context.Context, patchManifest *reformav1
 		return parsedPatch, err
 	}
 
-	parsedPatch = buffer.String()
-
 	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeTemplateSucceed,
 		metav1.ConditionTrue,
 		ConditionReasonTemplateParsed,
@@ -202,8 +361,13 @@ func (r *PatchReconciler) GetPatch(ctx context.Context, patchManifest *reformav1
 	return parsedPatch, err
 }
 
-// PatchTarget call Kubernetes API to actually patch the resource
-func (r *PatchReconciler) PatchTarget(ctx context.Context, patchManifest *reformav1beta1.Patch) (err error) {
+// PatchTarget call Kubernetes API to actually patch the resources. When several targets are selected,
+// all of them are patched even when some fail, and the result for each one is recorded in the status.
+// Targets not matching the 'when' expression are skipped
+func (r *PatchReconciler) PatchTarget(ctx context.Context, patchManifest reformav1beta1.PatchObject) (err error) {
+
+	ctx, span := startSpan(ctx, client.ObjectKeyFromObject(patchManifest), "PatchTarget")
+	defer func() { endSpan(span, err) }()
 
 	err = r.CheckPatchType(patchManifest)
 	if err != nil {
@@ -215,43 +379,268 @@ func (r *PatchReconciler) PatchTarget(ctx context.Context, patchManifest *reform
 		return err
 	}
 
-	patch, err := r.GetPatch(ctx, patchManifest)
+	// Get the client to talk with Kubernetes on behalf of the Patch
+	patchClient, err := r.GetPatchClient(patchManifest)
 	if err != nil {
 		return err
 	}
 
-	// Get the target to patch
-	target := &unstructured.Unstructured{}
-	target.SetGroupVersionKind(patchManifest.Spec.Target.GroupVersionKind())
-	err = r.Get(ctx, client.ObjectKey{
-		Namespace: patchManifest.Spec.Target.Namespace,
-		Name:      patchManifest.Spec.Target.Name,
-	}, target)
+	// Get the targets to patch
+	targets, err := r.GetTargets(ctx, patchClient, patchManifest)
 	if err != nil {
+		if r.updateForbiddenCondition(patchManifest, err) {
+			return err
+		}
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonInvalidTargetSelector,
+			ConditionReasonInvalidTargetSelectorMessage,
+		))
 		return err
 	}
 
-	//
-	parsedPatch := []byte(patch)
+	// Every target is recorded in the status, so selectors can not grow it without limit.
+	// The status of the previous synchronization is kept, so its targets can still be released or reverted
+	if maxTargets := r.getMaxSelectedTargets(); len(targets) > maxTargets {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonTooManyTargets,
+			fmt.Sprintf(ConditionReasonTooManyTargetsMessage, len(targets), maxTargets),
+		))
+		return NewErrorf(tooManyTargetsError, len(targets), maxTargets)
+	}
+
+	// Keep the status of the previous synchronization, to know what was applied on each target
+	previousTargets := patchManifest.GetStatus().Targets
+	patchManifest.GetStatus().Targets = nil
+	patchManifest.GetStatus().Sources = nil
+
+	var targetErrors []error
+	driftedTargets := 0
+	for _, targetReference := range targets {
+		previous := getPreviousTargetStatus(previousTargets, patchManifest, targetReference)
+		sync := getPreviousTargetSync(previous)
+
+		// The target and the sources are got once, and shared by the 'when' expression and all the steps
+		resources, err := r.GetResources(ctx, patchClient, patchManifest, targetReference)
 
-	// Convert the YAML patch to JSON for client-side patches, remember, Kubernetes API expect JSON for them
-	if patchManifest.Spec.PatchType != types.ApplyPatchType {
-		parsedPatch, err = yaml.YAMLToJSON([]byte(patch))
+		patchable := false
+		if err == nil {
+			patchable, err = r.EvaluateWhen(patchManifest, resources)
+		}
+		if err == nil && !patchable {
+			r.UpdateTargetSkippedStatus(patchManifest, targetReference)
+			r.RecordTargetEvent(patchManifest, previous, getLastTargetStatus(patchManifest), "")
+			continue
+		}
+
+		if err == nil {
+			sync, err = r.patchSingleTarget(ctx, patchClient, patchManifest, targetReference, previous, resources)
+		}
+		r.UpdateTargetStatus(patchManifest, targetReference, sync, err)
+		r.RecordTargetEvent(patchManifest, previous, getLastTargetStatus(patchManifest), sync.uid)
 		if err != nil {
-			return err
+			targetErrors = append(targetErrors, err)
+		}
+		if sync.drifted {
+			driftedTargets++
+		}
+		if err == nil {
+			r.countTargetSync(patchManifest, sync)
 		}
 	}
 
-	// Actually perform the patch against Kubernetes
-	err = r.Patch(ctx, target, client.RawPatch(patchManifest.Spec.PatchType, parsedPatch))
+	r.UpdateDriftStatus(patchManifest, driftedTargets)
+
+	return errors.Join(targetErrors...)
+}
+
+// getPreviousTargetSync return what was applied on a target on the previous synchronization.
+// It is kept until the target is successfully patched again
+func getPreviousTargetSync(previous *reformav1beta1.TargetStatus) (sync targetSync) {
+	if previous != nil {
+		sync.resourceVersion = previous.ResourceVersion
+		sync.patchHash = previous.PatchHash
+		sync.appliedHash = previous.AppliedHash
+		sync.appliedPaths = previous.AppliedPaths
+	}
+	return sync
+}
+
+// patchSingleTarget perform all the steps of the Patch against one target, in order.
+// It stops on the first failing step. What was applied is returned, to detect later when the target drifts.
+// Targets that would be unchanged by the patches are not patched at all.
+// The resources are the ones returned by GetResources for the target, so it is not got again
+func (r *PatchReconciler) patchSingleTarget(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject,
+	targetReference corev1.ObjectReference, previous *reformav1beta1.TargetStatus, resources []interface{}) (sync targetSync, err error) {
+
+	sync = getPreviousTargetSync(previous)
+
+	// Missing targets are given as an empty object when they must be created from the first step.
+	// The target is copied, as patching it replaces its content
+	target := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if currentTarget, ok := resources[0].(map[string]interface{}); ok {
+		target.Object = runtime.DeepCopyJSON(currentTarget)
+	}
+	createTarget := len(target.Object) == 0
+
+	target.SetGroupVersionKind(targetReference.GroupVersionKind())
+	target.SetNamespace(getReferenceNamespace(patchManifest, targetReference))
+	target.SetName(targetReference.Name)
+
+	// Know whether the fields patched on the previous synchronization were changed by others
+	if !createTarget {
+		sync.uid = target.GetUID()
+		sync.resourceVersion = target.GetResourceVersion()
+		sync.drifted, err = isTargetDrifted(target.Object, previous)
+		if err != nil {
+			return sync, err
+		}
+	}
+
+	steps := GetPatchSteps(patchManifest)
+
+	// Render all the steps against the target as it is. When they are the same patches applied on the previous
+	// synchronization, and the patched fields did not change since then, the target would be unchanged.
+	// Following steps may need the changes done by the previous ones to render, so when they fail here
+	// the comparison is given up, and they are rendered again in order
+	patches := make([][]byte, len(steps))
+	canSkip := true
+	conditions := slices.Clone(patchManifest.GetStatus().Conditions)
+	for i, step := range steps {
+		patches[i], err = r.renderTargetStep(ctx, patchManifest, targetReference, target, step, resources)
+		if err != nil && i == 0 {
+			sync.failedStep = getFailedStepName(patchManifest, step, i)
+			return sync, err
+		}
+		if err != nil {
+			patchManifest.GetStatus().Conditions = conditions
+			canSkip, err = false, nil
+			break
+		}
+	}
+
+	if canSkip && !createTarget && isTargetUnchanged(previous, sync.drifted, getPatchesHash(patches)) {
+		sync.unchanged = true
+		return sync, err
+	}
+
+	var paths []string
+	for i, step := range steps {
+
+		// Following steps are rendered again, as they see the changes done by the previous ones.
+		// Sources are not got again, so all the steps see the same ones
+		if i > 0 {
+			stepResources := append([]interface{}{target.Object}, resources[1:]...)
+			patches[i], err = r.renderTargetStep(ctx, patchManifest, targetReference, target, step, stepResources)
+		}
+		if err == nil {
+			err = r.patchTargetStep(ctx, patchClient, patchManifest, target, step, i, patches[i], createTarget && i == 0)
+		}
+		if err != nil {
+			sync.failedStep = getFailedStepName(patchManifest, step, i)
+			return sync, err
+		}
+
+		stepPaths, err := getPatchPaths(step.PatchType, patches[i])
+		if err != nil {
+			return sync, err
+		}
+		paths = append(paths, stepPaths...)
+	}
+
+	// Remember the patched fields as they are right after patching them
+	sync.patchHash = getPatchesHash(patches)
+	sync.appliedPaths = getUniquePaths(paths)
+	sync.appliedHash, err = getPathsHash(target.Object, sync.appliedPaths)
+	sync.resourceVersion = target.GetResourceVersion()
+	sync.uid = target.GetUID()
+
+	return sync, err
+}
+
+// renderTargetStep render the template of a step for one target, returning the patch ready to be sent to Kubernetes
+func (r *PatchReconciler) renderTargetStep(ctx context.Context, patchManifest reformav1beta1.PatchObject,
+	targetReference corev1.ObjectReference, target *unstructured.Unstructured, step reformav1beta1.PatchStepSpec,
+	resources []interface{}) (parsedPatch []byte, err error) {
+
+	patch, err := r.GetPatch(ctx, patchManifest, step, targetReference, resources)
+	if err != nil {
+		return parsedPatch, err
+	}
+
+	// Convert the YAML patch to JSON, remember, Kubernetes API expect JSON for client-side patches.
+	// Applied patches are completed with the fields identifying the target
+	parsedPatch, err = yaml.YAMLToJSON([]byte(patch))
+	if err == nil && step.PatchType == types.ApplyPatchType {
+		parsedPatch, err = completeTargetManifest(parsedPatch, target)
+	}
 	if err != nil {
 		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
 			metav1.ConditionFalse,
 			ConditionReasonInvalidPatch,
 			ConditionReasonInvalidPatchMessage,
 		))
+	}
+
+	return parsedPatch, err
+}
+
+// patchTargetStep patch a target with the rendered patch of a step, or create it when requested.
+// The target is updated with the result
+func (r *PatchReconciler) patchTargetStep(ctx context.Context, patchClient client.Client, patchManifest reformav1beta1.PatchObject,
+	target *unstructured.Unstructured, step reformav1beta1.PatchStepSpec, stepIndex int, parsedPatch []byte,
+	createTarget bool) (err error) {
+
+	if createTarget {
+		return r.CreateTarget(ctx, patchClient, patchManifest, target, parsedPatch)
+	}
+
+	// Keep the target as it is before patching, to be able to revert the changes later.
+	// What is about to change is recorded before patching, so the changes are never lost.
+	// Applied steps are reverted releasing the owned fields instead
+	var previousTarget *unstructured.Unstructured
+	if patchManifest.GetSpec().DeletionPolicy == reformav1beta1.DeletionPolicyRevert && step.PatchType != types.ApplyPatchType {
+		previousTarget = target.DeepCopy()
+
+		err = r.RecordRevertPathsBeforePatch(ctx, patchClient, patchManifest, previousTarget, step, parsedPatch)
+		if err != nil {
+			return err
+		}
+	}
+
+	patchOptions := []client.PatchOption{}
+	if step.PatchType == types.ApplyPatchType {
+		patchOptions = getApplyOptions(patchManifest, getStepFieldManager(patchManifest, step, stepIndex))
+	}
+
+	// Actually perform the patch against Kubernetes
+	patchStart := time.Now()
+	err = patchClient.Patch(ctx, target, client.RawPatch(step.PatchType, parsedPatch), patchOptions...)
+	observeDuration(apiPatchDuration, patchStart, getPatchMetricLabels(patchManifest, string(step.PatchType))...)
+	if err != nil {
+		r.updatePatchFailedCondition(patchManifest, step, err)
 		return err
 	}
 
+	// Fields changed by others between the dry run and the patch are recorded too
+	if previousTarget != nil {
+		_, err = r.RecordRevertPaths(patchManifest, previousTarget, target)
+	}
+
 	return err
 }
+
+// updatePatchFailedCondition set the reason of a failed patch of a step on the Patch
+func (r *PatchReconciler) updatePatchFailedCondition(patchManifest reformav1beta1.PatchObject,
+	step reformav1beta1.PatchStepSpec, err error) {
+
+	if r.updateForbiddenCondition(patchManifest, err) || r.updateApplyConflictCondition(patchManifest, step, err) {
+		return
+	}
+	r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+		metav1.ConditionFalse,
+		ConditionReasonInvalidPatch,
+		ConditionReasonInvalidPatchMessage,
+	))
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestPatchTargetGetsResourcesOnce(t *testing.T) {
+	gets := map[string]int{}
+
+	patchManifest := newValidPatch()
+	patchManifest.Spec.Engine = reformav1beta1.TemplateEngineCEL
+	patchManifest.Spec.When = `target.metadata.name == "target"`
+	patchManifest.Spec.Sources = []reformav1beta1.SourceSpec{{
+		ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "source"},
+		Alias:           "source",
+	}}
+	patchManifest.Spec.Template, patchManifest.Spec.PatchType = "", ""
+	patchManifest.Spec.Steps = []reformav1beta1.PatchStepSpec{
+		{Template: `{"data": {"first": sources.source.data.key}}`, PatchType: types.MergePatchType},
+		{Template: `{"data": {"second": target.data.first}}`, PatchType: types.MergePatchType},
+	}
+
+	r := &PatchReconciler{
+		Client: fake.NewClientBuilder().
+			WithScheme(newTestScheme(t)).
+			WithRESTMapper(newTestRESTMapper()).
+			WithObjects(
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "target"}},
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "source"},
+					Data:       map[string]string{"key": "value"},
+				},
+			).
+			WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					gets[key.Name]++
+					return c.Get(ctx, key, obj, opts...)
+				},
+			}).
+			Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	err := r.PatchTarget(context.Background(), patchManifest)
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	// The target and the source are shared by the 'when' expression and all the steps
+	for _, name := range []string{"target", "source"} {
+		if gets[name] != 1 {
+			t.Errorf("got %d requests for %s, want 1", gets[name], name)
+		}
+	}
+
+	// Following steps see the changes done by the previous ones
+	target := &corev1.ConfigMap{}
+	err = r.Client.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "target"}, target)
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+	if target.Data["second"] != "value" {
+		t.Errorf("got data %v, want the second step to see the first one", target.Data)
+	}
+}
+
+func TestGetSourceListRecordsDigest(t *testing.T) {
+	source := reformav1beta1.SourceSpec{
+		ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap"},
+		Selector: &reformav1beta1.SourceSelectorSpec{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"selected": "true"}},
+		},
+	}
+
+	patchClient := fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithRESTMapper(newTestRESTMapper()).
+		WithObjects(newSelectedConfigMaps(3)...).
+		Build()
+	r := &PatchReconciler{Client: patchClient}
+
+	getSourceStatus := func() reformav1beta1.SourceStatus {
+		t.Helper()
+
+		patchManifest := newValidPatch()
+		objects, err := r.getSourceList(context.Background(), patchClient, patchManifest, source)
+		if err != nil {
+			t.Fatalf("got error %v, want none", err)
+		}
+		if len(objects) != 3 {
+			t.Errorf("got %d objects, want 3", len(objects))
+		}
+		if len(patchManifest.Status.Sources) != 1 {
+			t.Fatalf("got sources %+v, want a single one", patchManifest.Status.Sources)
+		}
+		return patchManifest.Status.Sources[0]
+	}
+
+	sourceStatus := getSourceStatus()
+	if sourceStatus.Selected != 3 || sourceStatus.Digest == "" || sourceStatus.LabelSelector != "selected=true" {
+		t.Errorf("got source %+v, want the digest of 3 objects selected by selected=true", sourceStatus)
+	}
+
+	if repeatedStatus := getSourceStatus(); repeatedStatus != sourceStatus {
+		t.Errorf("got source %+v, want %+v when nothing changed", repeatedStatus, sourceStatus)
+	}
+
+	// Changing any selected object changes the digest
+	configMap := &corev1.ConfigMap{}
+	err := patchClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "target-1"}, configMap)
+	if err == nil {
+		configMap.Data = map[string]string{"key": "changed"}
+		err = patchClient.Update(context.Background(), configMap)
+	}
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	if changedStatus := getSourceStatus(); changedStatus.Digest == sourceStatus.Digest {
+		t.Errorf("got the same digest %s, want a new one after changing an object", changedStatus.Digest)
+	}
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultMaxSelectedTargets is the number of targets a selector can select at most by default
+	DefaultMaxSelectedTargets = 100
+
+	tooManyTargetsError = "Target selector selects %d targets, but %d at most are allowed"
+)
+
+// getSelector return the selector for a label selector. Empty selectors match everything
+func getSelector(labelSelector *metav1.LabelSelector) (selector labels.Selector, err error) {
+	if labelSelector == nil {
+		return labels.Everything(), err
+	}
+	return metav1.LabelSelectorAsSelector(labelSelector)
+}
+
+// getTargetNamespaces return the set of namespaces selected by the namespace selector of the targets.
+// A nil set means that the namespace is not filtered by the selector
+func (r *PatchReconciler) getTargetNamespaces(ctx context.Context, patchClient client.Client,
+	targetSelector *reformav1beta1.TargetSelectorSpec) (namespaces map[string]bool, err error) {
+
+	if targetSelector.NamespaceSelector == nil {
+		return namespaces, err
+	}
+
+	selector, err := getSelector(targetSelector.NamespaceSelector)
+	if err != nil {
+		return namespaces, err
+	}
+
+	namespaceList := &corev1.NamespaceList{}
+	err = patchClient.List(ctx, namespaceList, client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return namespaces, err
+	}
+
+	namespaces = map[string]bool{}
+	for _, namespace := range namespaceList.Items {
+		namespaces[namespace.Name] = true
+	}
+
+	return namespaces, err
+}
+
+// getMaxSelectedTargets return the number of targets a selector can select at most
+func (r *PatchReconciler) getMaxSelectedTargets() int {
+	if r.MaxSelectedTargets <= 0 {
+		return DefaultMaxSelectedTargets
+	}
+	return r.MaxSelectedTargets
+}
+
+// GetTargets return the references of all the targets to patch.
+// Patches without TargetSelector only have the target defined in their spec, while selected objects
+// are excluded when the Patch is not permitted to use them
+func (r *PatchReconciler) GetTargets(ctx context.Context, patchClient client.Client,
+	patchManifest reformav1beta1.PatchObject) (targets []corev1.ObjectReference, err error) {
+
+	targetSelector := patchManifest.GetSpec().TargetSelector
+	if targetSelector == nil {
+		targets = append(targets, patchManifest.GetSpec().Target)
+		return targets, err
+	}
+
+	selector, err := getSelector(targetSelector.LabelSelector)
+	if err != nil {
+		return targets, err
+	}
+
+	namespaces, err := r.getTargetNamespaces(ctx, patchClient, targetSelector)
+	if err != nil {
+		return targets, err
+	}
+
+	listOptions := []client.ListOption{client.MatchingLabelsSelector{Selector: selector}}
+
+	// Namespaced Patches look for targets in their own namespace by default
+	if targetSelector.NamespaceSelector == nil && patchManifest.GetNamespace() != "" {
+		listOptions = append(listOptions, client.InNamespace(patchManifest.GetNamespace()))
+	}
+
+	targetList := &unstructured.UnstructuredList{}
+	targetList.SetAPIVersion(targetSelector.APIVersion)
+	targetList.SetKind(targetSelector.Kind + "List")
+
+	err = patchClient.List(ctx, targetList, listOptions...)
+	if err != nil {
+		return targets, err
+	}
+
+	for _, target := range targetList.Items {
+
+		// Cluster-scoped targets are not filtered by namespace
+		if namespaces != nil && target.GetNamespace() != "" && !namespaces[target.GetNamespace()] {
+			continue
+		}
+
+		targetReference := corev1.ObjectReference{
+			APIVersion: targetSelector.APIVersion,
+			Kind:       targetSelector.Kind,
+			Namespace:  target.GetNamespace(),
+			Name:       target.GetName(),
+		}
+
+		// Objects not permitted are excluded, so they never appear in the status of the Patch
+		permitted, err := r.isReferencePermitted(ctx, patchManifest, targetReference, reformav1beta1.ReferenceUsageTarget)
+		if err != nil {
+			return targets, err
+		}
+
+		if permitted {
+			targets = append(targets, targetReference)
+		}
+	}
+
+	return targets, err
+}
+
+// UpdateTargetStatus record the result of patching a target inside the status of the CR.
+// The reason of failures is taken from the condition set by the failing stage
+func (r *PatchReconciler) UpdateTargetStatus(patchManifest reformav1beta1.PatchObject, target corev1.ObjectReference,
+	sync targetSync, err error) {
+
+	targetStatus := reformav1beta1.TargetStatus{
+		APIVersion:      target.APIVersion,
+		Kind:            target.Kind,
+		Namespace:       getReferenceNamespace(patchManifest, target),
+		Name:            target.Name,
+		Status:          metav1.ConditionTrue,
+		Reason:          ConditionReasonTargetPatched,
+		Message:         ConditionReasonTargetPatchedMessage,
+		ResourceVersion: sync.resourceVersion,
+		Drifted:         sync.drifted,
+		PatchHash:       sync.patchHash,
+		AppliedHash:     sync.appliedHash,
+		AppliedPaths:    sync.appliedPaths,
+	}
+
+	if sync.unchanged {
+		targetStatus.Reason = ConditionReasonTargetUnchanged
+		targetStatus.Message = ConditionReasonTargetUnchangedMessage
+	}
+
+	if err != nil {
+		targetStatus.Status = metav1.ConditionFalse
+		targetStatus.Reason = ConditionReasonInvalidPatch
+		targetStatus.Message = err.Error()
+		targetStatus.Step = sync.failedStep
+
+		condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched)
+		if condition != nil && condition.Status == metav1.ConditionFalse {
+			targetStatus.Reason = condition.Reason
+		}
+	}
+
+	patchManifest.GetStatus().Targets = append(patchManifest.GetStatus().Targets, targetStatus)
+}
+
+// UpdateTargetSkippedStatus record inside the status of the CR that a target was skipped
+func (r *PatchReconciler) UpdateTargetSkippedStatus(patchManifest reformav1beta1.PatchObject, target corev1.ObjectReference) {
+
+	patchManifest.GetStatus().Targets = append(patchManifest.GetStatus().Targets, reformav1beta1.TargetStatus{
+		APIVersion: target.APIVersion,
+		Kind:       target.Kind,
+		Namespace:  getReferenceNamespace(patchManifest, target),
+		Name:       target.Name,
+		Status:     metav1.ConditionTrue,
+		Reason:     ConditionReasonSkipped,
+		Message:    ConditionReasonSkippedMessage,
+	})
+}
+
+// getLastTargetStatus return the status of the last target recorded inside the status of the CR
+func getLastTargetStatus(patchManifest reformav1beta1.PatchObject) reformav1beta1.TargetStatus {
+	targets := patchManifest.GetStatus().Targets
+	return targets[len(targets)-1]
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newSelectedConfigMaps return the given number of ConfigMaps selected by the label 'selected'
+func newSelectedConfigMaps(count int) (objects []client.Object) {
+	for i := 0; i < count; i++ {
+		objects = append(objects, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      fmt.Sprintf("target-%d", i),
+				Labels:    map[string]string{"selected": "true"},
+			},
+		})
+	}
+	return objects
+}
+
+func TestPatchTargetLimitsSelectedTargets(t *testing.T) {
+	previousTargets := []reformav1beta1.TargetStatus{{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "target-0"}}
+
+	tests := []struct {
+		name        string
+		targets     int
+		wantErr     bool
+		wantReason  string
+		wantTargets int
+	}{
+		{
+			name:        "targets under the limit are patched",
+			targets:     2,
+			wantReason:  ConditionReasonTargetPatched,
+			wantTargets: 2,
+		},
+		{
+			name:        "targets over the limit are not patched",
+			targets:     3,
+			wantErr:     true,
+			wantReason:  ConditionReasonTooManyTargets,
+			wantTargets: len(previousTargets),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patchManifest := newValidPatch()
+			patchManifest.Spec.Target = corev1.ObjectReference{}
+			patchManifest.Spec.TargetSelector = &reformav1beta1.TargetSelectorSpec{
+				APIVersion:    "v1",
+				Kind:          "ConfigMap",
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"selected": "true"}},
+			}
+			patchManifest.Status.Targets = previousTargets
+
+			r := &PatchReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(newTestScheme(t)).
+					WithRESTMapper(newTestRESTMapper()).
+					WithObjects(newSelectedConfigMaps(test.targets)...).
+					Build(),
+				Recorder:           record.NewFakeRecorder(10),
+				MaxSelectedTargets: 2,
+			}
+
+			err := r.PatchTarget(context.Background(), patchManifest)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			condition := r.GetPatchCondition(patchManifest, ConditionTypeResourcePatched)
+			if test.wantErr && (condition == nil || condition.Reason != test.wantReason) {
+				t.Errorf("got condition %+v, want reason %s", condition, test.wantReason)
+			}
+			if len(patchManifest.Status.Targets) != test.wantTargets {
+				t.Errorf("got %d targets in the status, want %d", len(patchManifest.Status.Targets), test.wantTargets)
+			}
+			if test.wantErr && !reflect.DeepEqual(patchManifest.Status.Targets, previousTargets) {
+				t.Errorf("got targets %+v, want the previous ones %+v", patchManifest.Status.Targets, previousTargets)
+			}
+			for _, targetStatus := range patchManifest.Status.Targets {
+				if !test.wantErr && targetStatus.Reason != test.wantReason {
+					t.Errorf("got target %+v, want reason %s", targetStatus, test.wantReason)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"context"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// tracerName is the name of the tracer creating the spans of the reconciliation pipeline
+	tracerName = "prosimcorp.com/reforma"
+
+	spanAttributePatchNamespace = "reforma.patch.namespace"
+	spanAttributePatchName      = "reforma.patch.name"
+	spanAttributeGVK            = "reforma.gvk"
+	spanAttributeGVKs           = "reforma.gvks"
+	spanAttributeNamespace      = "reforma.namespace"
+	spanAttributeName           = "reforma.name"
+	spanAttributeEngine         = "reforma.engine"
+	spanAttributeStep           = "reforma.step"
+	spanAttributeOutcome        = "reforma.outcome"
+	spanAttributeReason         = "reforma.reason"
+
+	spanOutcomeSuccess = "success"
+	spanOutcomeFailure = "failure"
+)
+
+// startSpan start a span of the reconciliation pipeline for a Patch. Spans are discarded
+// when no tracer provider is configured
+func startSpan(ctx context.Context, patchKey types.NamespacedName, name string,
+	attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+
+	attributes = append(attributes,
+		attribute.String(spanAttributePatchNamespace, patchKey.Namespace),
+		attribute.String(spanAttributePatchName, patchKey.Name),
+	)
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan end a span recording its outcome, and the error when it failed
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String(spanAttributeOutcome, spanOutcomeFailure))
+	} else {
+		span.SetAttributes(attribute.String(spanAttributeOutcome, spanOutcomeSuccess))
+	}
+	span.End()
+}
+
+// getReferenceAttributes return the span attributes identifying a referenced object
+func getReferenceAttributes(patchManifest reformav1beta1.PatchObject, reference corev1.ObjectReference) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String(spanAttributeGVK, reference.GroupVersionKind().String()),
+		attribute.String(spanAttributeNamespace, getReferenceNamespace(patchManifest, reference)),
+		attribute.String(spanAttributeName, reference.Name),
+	}
+}
+
+// getSourcesAttribute return the span attribute with the kinds of all the sources of a Patch
+func getSourcesAttribute(patchManifest reformav1beta1.PatchObject) attribute.KeyValue {
+	sourceKinds := make([]string, 0, len(patchManifest.GetSpec().Sources))
+	for _, source := range patchManifest.GetSpec().Sources {
+		sourceKinds = append(sourceKinds, source.GroupVersionKind().String())
+	}
+	return attribute.StringSlice(spanAttributeGVKs, sourceKinds)
+}
@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// referencedObjectsIndexField is the name of the field index that relates a Patch with the objects it uses
+	referencedObjectsIndexField = ".spec.referencedObjects"
+
+	watchKindRegistered         = "Watching kind %s for changes in sources and targets"
+	watchKindRegistrationError  = "Can not watch kind %s: %s"
+	referencingPatchesListError = "Can not list the Patches referencing the object: %s"
+)
+
+// referencedObjectKey return the key used in the field index for an object. Version is excluded on purpose,
+// so any version of the same kind enqueues the Patches
+func referencedObjectKey(gvk schema.GroupVersionKind, namespace, name string) string {
+	return strings.Join([]string{gvk.Group, gvk.Kind, namespace, name}, "/")
+}
+
+// referencedKindKey return the key used in the field index for Patches selecting objects of a kind
+func referencedKindKey(gvk schema.GroupVersionKind) string {
+	return strings.Join([]string{gvk.Group, gvk.Kind, "*"}, "/")
+}
+
+// getReferencedObjects return the references of the target and the sources of a Patch.
+// Selected targets and sources are returned as references without name, as they represent the whole kind
+func getReferencedObjects(patchManifest reformav1beta1.PatchObject) (references []corev1.ObjectReference) {
+	if targetSelector := patchManifest.GetSpec().TargetSelector; targetSelector != nil {
+		references = append(references, corev1.ObjectReference{
+			APIVersion: targetSelector.APIVersion,
+			Kind:       targetSelector.Kind,
+		})
+	} else {
+		references = append(references, patchManifest.GetSpec().Target)
+	}
+
+	for _, source := range patchManifest.GetSpec().Sources {
+		reference := source.ObjectReference
+		if source.Selector != nil {
+			reference.Name = ""
+		}
+		references = append(references, reference)
+	}
+
+	return references
+}
+
+// indexReferencedObjects return the field index keys for all the objects a Patch depends on
+func indexReferencedObjects(obj client.Object) (keys []string) {
+	patchManifest, ok := obj.(reformav1beta1.PatchObject)
+	if !ok {
+		return keys
+	}
+
+	for _, reference := range getReferencedObjects(patchManifest) {
+		if reference.Name == "" {
+			keys = append(keys, referencedKindKey(reference.GroupVersionKind()))
+			continue
+		}
+
+		keys = append(keys, referencedObjectKey(reference.GroupVersionKind(), reference.Namespace, reference.Name))
+
+		// References without namespace point to the namespace of the Patch, unless the object is cluster-scoped.
+		// Scope is unknown here, so both keys are indexed
+		namespace := getReferenceNamespace(patchManifest, reference)
+		if namespace != reference.Namespace {
+			keys = append(keys, referencedObjectKey(reference.GroupVersionKind(), namespace, reference.Name))
+		}
+	}
+
+	return keys
+}
+
+// enqueueReferencingPatches return a reconcile request for each Patch using the given object as source or target,
+// including the Patches selecting objects of its kind
+func (r *PatchReconciler) enqueueReferencingPatches(ctx context.Context, obj client.Object) (requests []reconcile.Request) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	keys := []string{
+		referencedObjectKey(gvk, obj.GetNamespace(), obj.GetName()),
+		referencedKindKey(gvk),
+	}
+
+	enqueued := map[types.NamespacedName]bool{}
+
+	for _, key := range keys {
+		patchList := r.patchListType.DeepCopyObject().(client.ObjectList)
+		err := r.List(ctx, patchList, client.MatchingFields{referencedObjectsIndexField: key})
+		if err != nil {
+			LogErrorf(ctx, err, referencingPatchesListError, key)
+			continue
+		}
+
+		err = meta.EachListItem(patchList, func(item runtime.Object) error {
+			patchManifest := item.(client.Object)
+			namespacedName := types.NamespacedName{
+				Namespace: patchManifest.GetNamespace(),
+				Name:      patchManifest.GetName(),
+			}
+			if !enqueued[namespacedName] {
+				enqueued[namespacedName] = true
+				requests = append(requests, reconcile.Request{NamespacedName: namespacedName})
+			}
+			return nil
+		})
+		if err != nil {
+			LogErrorf(ctx, err, referencingPatchesListError, key)
+		}
+	}
+
+	return requests
+}
+
+// referencedObjectChangedPredicate filter the updates of the referenced objects that can change the result of a Patch.
+// Status-only changes are ignored, so busy objects do not synchronize the Patches on each heartbeat.
+// Objects without generation, like ConfigMaps or Secrets, do not bump it on changes, so all their updates pass
+func referencedObjectChangedPredicate() predicate.Predicate {
+	return predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		predicate.LabelChangedPredicate{},
+		predicate.AnnotationChangedPredicate{},
+		predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return e.ObjectNew != nil && e.ObjectNew.GetGeneration() == 0
+			},
+			CreateFunc:  func(event.CreateEvent) bool { return false },
+			DeleteFunc:  func(event.DeleteEvent) bool { return false },
+			GenericFunc: func(event.GenericEvent) bool { return false },
+		},
+	)
+}
+
+// WatchReferencedKinds register a watch for each kind used by the Patch that is not already being watched.
+// Only metadata is watched, as it is enough to know that something changed and keeps the cache small.
+// Kinds that can not be watched do not prevent watching the rest, and their errors are returned together
+func (r *PatchReconciler) WatchReferencedKinds(ctx context.Context, patchManifest reformav1beta1.PatchObject) (err error) {
+	r.watchedKindsMutex.Lock()
+	defer r.watchedKindsMutex.Unlock()
+
+	if r.watchedKinds == nil {
+		r.watchedKinds = map[schema.GroupVersionKind]bool{}
+	}
+
+	var watchErrors []error
+	for _, reference := range getReferencedObjects(patchManifest) {
+		gvk := reference.GroupVersionKind()
+		if r.watchedKinds[gvk] {
+			continue
+		}
+
+		// Unknown kinds are not watched, they will fail later when getting the resources
+		_, err = r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			watchErrors = append(watchErrors, NewErrorf(watchKindRegistrationError, gvk.String(), err.Error()))
+			continue
+		}
+
+		object := &metav1.PartialObjectMetadata{}
+		object.SetGroupVersionKind(gvk)
+
+		err = r.controller.Watch(source.Kind(r.cache, object), handler.EnqueueRequestsFromMapFunc(r.enqueueReferencingPatches),
+			referencedObjectChangedPredicate())
+		if err != nil {
+			watchErrors = append(watchErrors, NewErrorf(watchKindRegistrationError, gvk.String(), err.Error()))
+			continue
+		}
+
+		r.watchedKinds[gvk] = true
+		LogInfof(ctx, watchKindRegistered, gvk.String())
+	}
+
+	return errors.Join(watchErrors...)
+}
@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestScheme return a scheme with the core and Reforma kinds for the tests
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("can not add core kinds to the scheme: %v", err)
+	}
+	if err := reformav1beta1.AddToScheme(scheme); err != nil {
+		t.Fatalf("can not add Reforma kinds to the scheme: %v", err)
+	}
+	return scheme
+}
+
+func TestIndexReferencedObjects(t *testing.T) {
+	tests := []struct {
+		name string
+		spec reformav1beta1.PatchSpec
+		want []string
+	}{
+		{
+			name: "target and source without namespace are indexed in both scopes",
+			spec: reformav1beta1.PatchSpec{
+				Target: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"},
+				Sources: []reformav1beta1.SourceSpec{{
+					ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "config", Namespace: "other"},
+				}},
+			},
+			want: []string{
+				"/ConfigMap/other/config",
+				"apps/Deployment//app",
+				"apps/Deployment/default/app",
+			},
+		},
+		{
+			name: "selected targets and sources are indexed by kind",
+			spec: reformav1beta1.PatchSpec{
+				TargetSelector: &reformav1beta1.TargetSelectorSpec{APIVersion: "apps/v1", Kind: "Deployment"},
+				Sources: []reformav1beta1.SourceSpec{{
+					ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Name: "ignored"},
+					Selector:        &reformav1beta1.SourceSelectorSpec{},
+				}},
+			},
+			want: []string{
+				"/Secret/*",
+				"apps/Deployment/*",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patchManifest := &reformav1beta1.Patch{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "patch"},
+				Spec:       test.spec,
+			}
+
+			keys := indexReferencedObjects(patchManifest)
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("got %v, want %v", keys, test.want)
+			}
+		})
+	}
+
+	if keys := indexReferencedObjects(&corev1.ConfigMap{}); len(keys) != 0 {
+		t.Errorf("objects other than Patches must not be indexed, got %v", keys)
+	}
+}
+
+func TestEnqueueReferencingPatches(t *testing.T) {
+	scheme := newTestScheme(t)
+
+	byName := &reformav1beta1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "by-name"},
+		Spec: reformav1beta1.PatchSpec{
+			Target: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"},
+			Sources: []reformav1beta1.SourceSpec{{
+				ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "config"},
+			}},
+		},
+	}
+	bySelector := &reformav1beta1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "by-selector"},
+		Spec: reformav1beta1.PatchSpec{
+			Target: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"},
+			Sources: []reformav1beta1.SourceSpec{{
+				ObjectReference: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap"},
+				Selector:        &reformav1beta1.SourceSelectorSpec{},
+			}},
+		},
+	}
+	unrelated := &reformav1beta1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "unrelated"},
+		Spec: reformav1beta1.PatchSpec{
+			Target: corev1.ObjectReference{APIVersion: "v1", Kind: "Service", Name: "app"},
+		},
+	}
+
+	r := &PatchReconciler{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(byName, bySelector, unrelated).
+			WithIndex(&reformav1beta1.Patch{}, referencedObjectsIndexField, indexReferencedObjects).
+			Build(),
+		patchListType: &reformav1beta1.PatchList{},
+	}
+
+	tests := []struct {
+		name   string
+		object client.Object
+		want   []types.NamespacedName
+	}{
+		{
+			name:   "object referenced by name and selected by kind",
+			object: newPartialObject("v1", "ConfigMap", "default", "config"),
+			want: []types.NamespacedName{
+				{Namespace: "default", Name: "by-name"},
+				{Namespace: "other", Name: "by-selector"},
+			},
+		},
+		{
+			name:   "object only selected by kind",
+			object: newPartialObject("v1", "ConfigMap", "default", "another"),
+			want:   []types.NamespacedName{{Namespace: "other", Name: "by-selector"}},
+		},
+		{
+			name:   "object is matched in the namespace of each Patch",
+			object: newPartialObject("apps/v1", "Deployment", "default", "app"),
+			want:   []types.NamespacedName{{Namespace: "default", Name: "by-name"}},
+		},
+		{
+			name:   "object not referenced",
+			object: newPartialObject("v1", "Secret", "default", "config"),
+			want:   nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := r.enqueueReferencingPatches(context.Background(), test.object)
+
+			var got []types.NamespacedName
+			for _, request := range requests {
+				got = append(got, request.NamespacedName)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].String() < got[j].String() })
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	// Requests are not repeated when the object matches a Patch by name and by kind at once
+	byName.Spec.Sources = append(byName.Spec.Sources, bySelector.Spec.Sources...)
+	if err := r.Update(context.Background(), byName); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	requests := r.enqueueReferencingPatches(context.Background(), newPartialObject("v1", "ConfigMap", "default", "config"))
+	want := []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: "default", Name: "by-name"}},
+		{NamespacedName: types.NamespacedName{Namespace: "other", Name: "by-selector"}},
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].String() < requests[j].String() })
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("got %v, want %v", requests, want)
+	}
+}
+
+func TestReferencedObjectChangedPredicate(t *testing.T) {
+	tests := []struct {
+		name string
+		old  metav1.ObjectMeta
+		new  metav1.ObjectMeta
+		want bool
+	}{
+		{
+			name: "status only change is ignored",
+			old:  metav1.ObjectMeta{Generation: 1, ResourceVersion: "1"},
+			new:  metav1.ObjectMeta{Generation: 1, ResourceVersion: "2"},
+			want: false,
+		},
+		{
+			name: "generation change passes",
+			old:  metav1.ObjectMeta{Generation: 1},
+			new:  metav1.ObjectMeta{Generation: 2},
+			want: true,
+		},
+		{
+			name: "labels change passes",
+			old:  metav1.ObjectMeta{Generation: 1},
+			new:  metav1.ObjectMeta{Generation: 1, Labels: map[string]string{"a": "1"}},
+			want: true,
+		},
+		{
+			name: "annotations change passes",
+			old:  metav1.ObjectMeta{Generation: 1},
+			new:  metav1.ObjectMeta{Generation: 1, Annotations: map[string]string{"a": "1"}},
+			want: true,
+		},
+		{
+			name: "objects without generation always pass",
+			old:  metav1.ObjectMeta{ResourceVersion: "1"},
+			new:  metav1.ObjectMeta{ResourceVersion: "2"},
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := referencedObjectChangedPredicate().Update(event.UpdateEvent{
+				ObjectOld: &metav1.PartialObjectMetadata{ObjectMeta: test.old},
+				ObjectNew: &metav1.PartialObjectMetadata{ObjectMeta: test.new},
+			})
+			if got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+// newPartialObject return the metadata of an object as it is received from the watches
+func newPartialObject(apiVersion, kind, namespace, name string) *metav1.PartialObjectMetadata {
+	object := &metav1.PartialObjectMetadata{}
+	object.SetGroupVersionKind((&corev1.ObjectReference{APIVersion: apiVersion, Kind: kind}).GroupVersionKind())
+	object.SetNamespace(namespace)
+	object.SetName(name)
+	return object
+}
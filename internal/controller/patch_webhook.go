@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// notPatchObjectError error message for admission requests about objects that are not Patches
+	notPatchObjectError = "Expected a Patch or a ClusterPatch, but got %T"
+
+	// invalidTemplateError error message for templates that can not be parsed by their engine
+	invalidTemplateError = "%s can not parse it: %s"
+
+	missingTargetError      = "target or targetSelector must be defined"
+	multipleTargetsError    = "target and targetSelector can not be defined at once"
+	stepsDefinedError       = "can not be defined together with steps"
+	defaultNotOptionalError = "can only be defined for optional sources"
+	admissionRevertError    = "can not be enabled with the Revert deletion policy, as changes done on admission are not recorded"
+)
+
+//+kubebuilder:webhook:path=/validate-reforma-prosimcorp-com-v1beta1-patch,mutating=false,failurePolicy=fail,sideEffects=None,groups=reforma.prosimcorp.com,resources=patches,verbs=create;update,versions=v1beta1,name=vpatch.reforma.prosimcorp.com,admissionReviewVersions=v1
+
+// SetupWebhookWithManager sets up the webhook validating the Patches with the Manager
+func (r *PatchReconciler) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&reformav1beta1.Patch{}).
+		WithValidator(r).
+		Complete()
+}
+
+// getStepFieldPath return the path to a step inside the spec, to point to its fields on validation errors
+func getStepFieldPath(patchManifest reformav1beta1.PatchObject, index int) *field.Path {
+	specPath := field.NewPath("spec")
+	if len(patchManifest.GetSpec().Steps) == 0 {
+		return specPath
+	}
+	return specPath.Child("steps").Index(index)
+}
+
+// ValidatePatch check the fields of a Patch that are only checked when synchronizing it otherwise:
+// how the targets are chosen, the synchronization time, the patch types, the defaults of the sources,
+// the field paths of the replacements, and the syntax of the templates and the 'when' expression. Templates are only parsed, as rendering them needs the target and the sources
+func (r *PatchReconciler) ValidatePatch(patchManifest reformav1beta1.PatchObject) (errs field.ErrorList) {
+	spec := patchManifest.GetSpec()
+
+	synchronizationTimePath := field.NewPath("spec", "synchronization", "time")
+	if _, err := time.ParseDuration(spec.Synchronization.Time); err != nil {
+		errs = append(errs, field.Invalid(synchronizationTimePath, spec.Synchronization.Time, err.Error()))
+	}
+
+	// Exactly one way of choosing the targets is allowed. Target is serialized even when empty, so its fields are checked
+	targetDefined := spec.Target.Kind != "" || spec.Target.Name != ""
+	if !targetDefined && spec.TargetSelector == nil {
+		errs = append(errs, field.Required(field.NewPath("spec", "target"), missingTargetError))
+	}
+	if targetDefined && spec.TargetSelector != nil {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "targetSelector"), multipleTargetsError))
+	}
+
+	// Steps replace the template and the patch type of the Patch, so defining both is a mistake
+	if len(spec.Steps) > 0 && spec.Template != "" {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "template"), stepsDefinedError))
+	}
+	if len(spec.Steps) > 0 && spec.PatchType != "" {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "patchType"), stepsDefinedError))
+	}
+
+	if spec.When != "" {
+		if _, _, err := compileCELExpression(spec.When); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "when"), spec.When, err.Error()))
+		}
+	}
+
+	// Targets patched on admission have no previous values to revert to
+	if spec.AdmissionMutation && spec.DeletionPolicy == reformav1beta1.DeletionPolicyRevert {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "admissionMutation"), admissionRevertError))
+	}
+
+	// Defaults are only given to the templates when optional sources are not found
+	aliases := map[string]bool{}
+	for i, source := range spec.Sources {
+		aliases[source.Alias] = source.Alias != ""
+		if source.Default != nil && !source.Optional {
+			errs = append(errs, field.Forbidden(field.NewPath("spec", "sources").Index(i).Child("default"), defaultNotOptionalError))
+		}
+	}
+
+	errs = append(errs, validateReplacements(spec.Replacements, aliases)...)
+
+	// Replacements are computed without templates, so only their patch type is checked
+	engineName := templateEngineNames[getTemplateEngine(patchManifest)]
+	for i, step := range GetPatchSteps(patchManifest) {
+		stepPath := getStepFieldPath(patchManifest, i)
+
+		supported := false
+		for _, availablePatchType := range AvailabePatchTypes {
+			supported = supported || availablePatchType == step.PatchType
+		}
+		if !supported {
+			errs = append(errs, field.NotSupported(stepPath.Child("patchType"), step.PatchType, GetPatchTypesString()))
+		}
+
+		if len(spec.Replacements) > 0 {
+			continue
+		}
+
+		if err := r.ParseTemplate(patchManifest, step); err != nil {
+			// Templates are usually long, so they are not repeated in the error
+			errs = append(errs, field.Invalid(stepPath.Child("template"), field.OmitValueType{},
+				fmt.Sprintf(invalidTemplateError, engineName, err.Error())))
+		}
+	}
+
+	return errs
+}
+
+// validateReplacements check that the replacements copy values from existing sources, using valid field paths
+func validateReplacements(replacements []reformav1beta1.ReplacementSpec, aliases map[string]bool) (errs field.ErrorList) {
+	for i, replacement := range replacements {
+		replacementPath := field.NewPath("spec", "replacements").Index(i)
+
+		if !aliases[replacement.Source.Alias] {
+			errs = append(errs, field.Invalid(replacementPath.Child("source", "alias"), replacement.Source.Alias,
+				fmt.Sprintf(replacementSourceNotFoundError, replacement.Source.Alias)))
+		}
+
+		// The path of the source is 'metadata.name' when empty
+		if replacement.Source.FieldPath != "" {
+			if _, err := parseFieldPath(replacement.Source.FieldPath); err != nil {
+				errs = append(errs, field.Invalid(replacementPath.Child("source", "fieldPath"), replacement.Source.FieldPath, err.Error()))
+			}
+		}
+
+		for j, target := range replacement.Targets {
+			for k, fieldPath := range target.FieldPaths {
+				if _, err := parseFieldPath(fieldPath); err != nil {
+					errs = append(errs, field.Invalid(replacementPath.Child("targets").Index(j).Child("fieldPaths").Index(k),
+						fieldPath, err.Error()))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// validatePatchObject return an error describing all the invalid fields of a Patch, or nil when it is valid
+func (r *PatchReconciler) validatePatchObject(obj runtime.Object) (err error) {
+	patchManifest, ok := obj.(reformav1beta1.PatchObject)
+	if !ok {
+		return NewErrorf(notPatchObjectError, obj)
+	}
+
+	errs := r.ValidatePatch(patchManifest)
+	if len(errs) == 0 {
+		return err
+	}
+
+	return apierrors.NewInvalid(obj.GetObjectKind().GroupVersionKind().GroupKind(), patchManifest.GetName(), errs)
+}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (r *PatchReconciler) ValidateCreate(ctx context.Context, obj runtime.Object) (warnings admission.Warnings, err error) {
+	return warnings, r.validatePatchObject(obj)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type.
+// Updates not changing the spec are always allowed, so Patches created before the webhook can still be deleted
+func (r *PatchReconciler) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (warnings admission.Warnings, err error) {
+	oldPatch, oldOk := oldObj.(reformav1beta1.PatchObject)
+	newPatch, newOk := newObj.(reformav1beta1.PatchObject)
+	if oldOk && newOk && apiequality.Semantic.DeepEqual(oldPatch.GetSpec(), newPatch.GetSpec()) {
+		return warnings, err
+	}
+
+	return warnings, r.validatePatchObject(newObj)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (r *PatchReconciler) ValidateDelete(ctx context.Context, obj runtime.Object) (warnings admission.Warnings, err error) {
+	return warnings, err
+}
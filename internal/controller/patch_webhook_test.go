@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newValidPatch return a Patch passing the validation, for the tests to break it
+func newValidPatch() *reformav1beta1.Patch {
+	return &reformav1beta1.Patch{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "patch"},
+		Spec: reformav1beta1.PatchSpec{
+			Synchronization: reformav1beta1.SynchronizationSpec{Time: "1m"},
+			Target:          corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "target"},
+			Template:        `{"data":{"key":"value"}}`,
+			PatchType:       types.MergePatchType,
+		},
+	}
+}
+
+// assertValidationErrors check that a Patch is valid, or that its errors point to the given fields
+func assertValidationErrors(t *testing.T, patchManifest reformav1beta1.PatchObject, wantFields ...string) {
+	t.Helper()
+
+	errs := (&PatchReconciler{}).ValidatePatch(patchManifest)
+
+	var gotFields []string
+	for _, err := range errs {
+		gotFields = append(gotFields, err.Field)
+	}
+	if strings.Join(gotFields, ",") != strings.Join(wantFields, ",") {
+		t.Errorf("got errors %v, want errors on %v", errs, wantFields)
+	}
+}
+
+func TestValidatePatchTargets(t *testing.T) {
+	selector := &reformav1beta1.TargetSelectorSpec{APIVersion: "v1", Kind: "ConfigMap"}
+
+	tests := []struct {
+		name       string
+		target     corev1.ObjectReference
+		selector   *reformav1beta1.TargetSelectorSpec
+		wantFields []string
+	}{
+		{
+			name:   "target",
+			target: corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "target"},
+		},
+		{
+			name:     "target selector",
+			selector: selector,
+		},
+		{
+			name:       "no target",
+			wantFields: []string{"spec.target"},
+		},
+		{
+			name:       "target and target selector",
+			target:     corev1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Name: "target"},
+			selector:   selector,
+			wantFields: []string{"spec.targetSelector"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patchManifest := newValidPatch()
+			patchManifest.Spec.Target = test.target
+			patchManifest.Spec.TargetSelector = test.selector
+
+			assertValidationErrors(t, patchManifest, test.wantFields...)
+		})
+	}
+}
+
+func TestValidatePatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		mutate     func(spec *reformav1beta1.PatchSpec)
+		wantFields []string
+	}{
+		{
+			name:   "valid Patch",
+			mutate: func(spec *reformav1beta1.PatchSpec) {},
+		},
+		{
+			name:       "invalid synchronization time",
+			mutate:     func(spec *reformav1beta1.PatchSpec) { spec.Synchronization.Time = "often" },
+			wantFields: []string{"spec.synchronization.time"},
+		},
+		{
+			name:       "invalid when expression",
+			mutate:     func(spec *reformav1beta1.PatchSpec) { spec.When = "target.metadata.name ==" },
+			wantFields: []string{"spec.when"},
+		},
+		{
+			name:       "unsupported patch type",
+			mutate:     func(spec *reformav1beta1.PatchSpec) { spec.PatchType = "application/unknown" },
+			wantFields: []string{"spec.patchType"},
+		},
+		{
+			name:       "invalid template",
+			mutate:     func(spec *reformav1beta1.PatchSpec) { spec.Template = `{{ .unclosed` },
+			wantFields: []string{"spec.template"},
+		},
+		{
+			name: "steps",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.Steps = []reformav1beta1.PatchStepSpec{{Template: spec.Template, PatchType: spec.PatchType}}
+				spec.Template, spec.PatchType = "", ""
+			},
+		},
+		{
+			name: "invalid step",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.Steps = []reformav1beta1.PatchStepSpec{
+					{Template: spec.Template, PatchType: spec.PatchType},
+					{Template: `{{ .unclosed`, PatchType: "application/unknown"},
+				}
+				spec.Template, spec.PatchType = "", ""
+			},
+			wantFields: []string{"spec.steps[1].patchType", "spec.steps[1].template"},
+		},
+		{
+			name: "default of an optional source",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.Sources = []reformav1beta1.SourceSpec{{Optional: true, Default: &apiextensionsv1.JSON{Raw: []byte(`{}`)}}}
+			},
+		},
+		{
+			name: "default of a required source",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.Sources = []reformav1beta1.SourceSpec{{Default: &apiextensionsv1.JSON{Raw: []byte(`{}`)}}}
+			},
+			wantFields: []string{"spec.sources[0].default"},
+		},
+		{
+			name: "replacements",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.Sources = []reformav1beta1.SourceSpec{{Alias: "source"}}
+				spec.Template, spec.PatchType = "", types.JSONPatchType
+				spec.Replacements = []reformav1beta1.ReplacementSpec{{
+					Source:  reformav1beta1.ReplacementSourceSpec{Alias: "source", FieldPath: "data.[a.b]"},
+					Targets: []reformav1beta1.ReplacementTargetSpec{{FieldPaths: []string{"spec.containers.[name=app].image"}}},
+				}}
+			},
+		},
+		{
+			name: "invalid replacements",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.Template, spec.PatchType = "", types.JSONPatchType
+				spec.Replacements = []reformav1beta1.ReplacementSpec{{
+					Source:  reformav1beta1.ReplacementSourceSpec{Alias: "missing", FieldPath: "data.[unclosed"},
+					Targets: []reformav1beta1.ReplacementTargetSpec{{FieldPaths: []string{"data.key", ""}}},
+				}}
+			},
+			wantFields: []string{
+				"spec.replacements[0].source.alias",
+				"spec.replacements[0].source.fieldPath",
+				"spec.replacements[0].targets[0].fieldPaths[1]",
+			},
+		},
+		{
+			name: "admission mutation",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.AdmissionMutation = true
+			},
+		},
+		{
+			name: "admission mutation with the Revert deletion policy",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.AdmissionMutation, spec.DeletionPolicy = true, reformav1beta1.DeletionPolicyRevert
+			},
+			wantFields: []string{"spec.admissionMutation"},
+		},
+		{
+			name: "steps with template and patch type",
+			mutate: func(spec *reformav1beta1.PatchSpec) {
+				spec.Steps = []reformav1beta1.PatchStepSpec{{Template: spec.Template, PatchType: spec.PatchType}}
+			},
+			wantFields: []string{"spec.template", "spec.patchType"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patchManifest := newValidPatch()
+			test.mutate(&patchManifest.Spec)
+
+			assertValidationErrors(t, patchManifest, test.wantFields...)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/lru"
+)
+
+const (
+	// celCostLimit is the maximum cost of evaluating a CEL expression, to avoid expensive ones blocking the controller
+	celCostLimit = 1000000
+
+	// celMaxCachedPrograms is the maximum number of compiled CEL programs kept, dropping the least used ones
+	celMaxCachedPrograms = 1000
+
+	// whenNotBooleanError error message for 'when' expressions not returning a boolean
+	whenNotBooleanError = "When expression must return a boolean, got: %v"
+)
+
+// celPrograms cache the compiled CEL programs, so expressions are not compiled again on each synchronization
+var celPrograms = lru.New(celMaxCachedPrograms)
+
+// newCELEnvironment return the CEL environment where expressions of the Patch are compiled.
+// The variables are the same data given to templates using the Structured context
+func newCELEnvironment() (environment *cel.Env, err error) {
+	return cel.NewEnv(
+		cel.Variable("target", cel.DynType),
+		cel.Variable("sources", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("resources", cel.ListType(cel.DynType)),
+		cel.Variable("patch", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("values", cel.DynType),
+		ext.Strings(),
+	)
+}
+
+// getEngineVariables return the values of the variables given to CEL expressions and Jsonnet programs
+func (r *PatchReconciler) getEngineVariables(patchManifest reformav1beta1.PatchObject,
+	resources []interface{}) (variables map[string]interface{}, err error) {
+
+	structuredContext, err := r.GetStructuredTemplateContext(patchManifest, resources)
+	if err != nil {
+		return variables, err
+	}
+
+	variables = map[string]interface{}{
+		"target":    structuredContext.Target,
+		"sources":   structuredContext.Sources,
+		"resources": resources,
+		"patch":     structuredContext.Patch,
+		"values":    structuredContext.Values,
+	}
+
+	return variables, err
+}
+
+// compileCELExpression compile a CEL expression, returning the environment it must be evaluated with
+func compileCELExpression(expression string) (environment *cel.Env, ast *cel.Ast, err error) {
+	environment, err = newCELEnvironment()
+	if err != nil {
+		return environment, ast, err
+	}
+
+	ast, issues := environment.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return environment, ast, issues.Err()
+	}
+
+	return environment, ast, err
+}
+
+// getCELProgram return the program of a CEL expression, compiling it only when it is not cached yet.
+// Programs are cached by the hash of the expression, as they are safe to be evaluated concurrently
+func getCELProgram(expression string) (program cel.Program, err error) {
+	expressionHash := fmt.Sprintf("%x", sha256.Sum256([]byte(expression)))
+
+	if cachedProgram, ok := celPrograms.Get(expressionHash); ok {
+		return cachedProgram.(cel.Program), err
+	}
+
+	environment, ast, err := compileCELExpression(expression)
+	if err != nil {
+		return program, err
+	}
+
+	program, err = environment.Program(ast, cel.CostLimit(celCostLimit))
+	if err != nil {
+		return program, err
+	}
+
+	celPrograms.Add(expressionHash, program)
+	return program, err
+}
+
+// evaluateCELProgram evaluate an already compiled CEL expression against the given variables
+func evaluateCELProgram(program cel.Program, variables map[string]interface{}) (result ref.Val, err error) {
+	result, _, err = program.Eval(variables)
+	return result, err
+}
+
+// evaluateCELExpression compile and evaluate a CEL expression against the given variables
+func evaluateCELExpression(expression string, variables map[string]interface{}) (result interface{}, err error) {
+
+	program, err := getCELProgram(expression)
+	if err != nil {
+		return result, err
+	}
+
+	value, err := evaluateCELProgram(program, variables)
+	if err != nil {
+		return result, err
+	}
+
+	return value.Value(), err
+}
+
+// EvaluateWhen return whether a target must be patched, according to the 'when' expression of the Patch.
+// The resources are the ones returned by GetResources for the target.
+// Targets are always patched when the expression is empty
+func (r *PatchReconciler) EvaluateWhen(patchManifest reformav1beta1.PatchObject,
+	resources []interface{}) (patchable bool, err error) {
+
+	if patchManifest.GetSpec().When == "" {
+		return true, err
+	}
+
+	variables, err := r.getEngineVariables(patchManifest, resources)
+	if err == nil {
+		var result interface{}
+		result, err = evaluateCELExpression(patchManifest.GetSpec().When, variables)
+
+		var isBoolean bool
+		if patchable, isBoolean = result.(bool); err == nil && !isBoolean {
+			err = NewErrorf(whenNotBooleanError, result)
+		}
+	}
+
+	if err != nil {
+		r.UpdatePatchCondition(patchManifest, r.NewPatchCondition(ConditionTypeResourcePatched,
+			metav1.ConditionFalse,
+			ConditionReasonInvalidWhen,
+			fmt.Sprintf(ConditionReasonInvalidWhenMessage, err.Error()),
+		))
+	}
+
+	return patchable, err
+}
+
+// isPatchSkipped return whether all the targets of the last synchronization were skipped
+func isPatchSkipped(patchManifest reformav1beta1.PatchObject) bool {
+	if len(patchManifest.GetStatus().Targets) == 0 {
+		return false
+	}
+
+	for _, targetStatus := range patchManifest.GetStatus().Targets {
+		if targetStatus.Reason != ConditionReasonSkipped {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"encoding/json"
+
+	reformav1beta1 "prosimcorp.com/reforma/api/v1beta1"
+)
+
+// StructuredTemplateContext is the data given to templates using the Structured context
+type StructuredTemplateContext struct {
+
+	// Target is the object being patched
+	Target interface{}
+
+	// Sources are the sources with alias, indexed by it
+	Sources map[string]interface{}
+
+	// Patch is the metadata of the Patch being synchronized
+	Patch map[string]interface{}
+
+	// Values are the arbitrary values defined in the Patch
+	Values interface{}
+}
+
+// GetTemplateContext return the data given to the template. Resources are expected in the order
+// returned by GetResources, the target followed by the sources, which is exactly the Legacy context
+func (r *PatchReconciler) GetTemplateContext(patchManifest reformav1beta1.PatchObject, resources []interface{}) (templateContext interface{}, err error) {
+
+	if patchManifest.GetSpec().TemplateContext != reformav1beta1.TemplateContextStructured {
+		return resources, err
+	}
+
+	return r.GetStructuredTemplateContext(patchManifest, resources)
+}
+
+// GetStructuredTemplateContext return the data given to templates using the Structured context,
+// no matter the context defined in the Patch
+func (r *PatchReconciler) GetStructuredTemplateContext(patchManifest reformav1beta1.PatchObject,
+	resources []interface{}) (structuredContext StructuredTemplateContext, err error) {
+
+	structuredContext = StructuredTemplateContext{
+		Sources: map[string]interface{}{},
+		Patch: map[string]interface{}{
+			"name":        patchManifest.GetName(),
+			"namespace":   patchManifest.GetNamespace(),
+			"labels":      patchManifest.GetLabels(),
+			"annotations": patchManifest.GetAnnotations(),
+		},
+	}
+
+	if len(resources) > 0 {
+		structuredContext.Target = resources[0]
+	}
+
+	for i, source := range patchManifest.GetSpec().Sources {
+		if source.Alias == "" || i+1 >= len(resources) {
+			continue
+		}
+		structuredContext.Sources[source.Alias] = resources[i+1]
+	}
+
+	if values := patchManifest.GetSpec().Values; values != nil {
+		err = json.Unmarshal(values.Raw, &structuredContext.Values)
+	}
+
+	return structuredContext, err
+}
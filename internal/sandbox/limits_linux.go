@@ -0,0 +1,70 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// processStatusPath is the file describing the memory of the current process
+	processStatusPath = "/proc/self/status"
+
+	// virtualMemoryField is the field of the process status holding its virtual memory, in kB
+	virtualMemoryField = "VmSize:"
+)
+
+// getVirtualMemory return the virtual memory reserved by the current process, in bytes
+func getVirtualMemory() (virtualMemory uint64, err error) {
+	status, err := os.Open(processStatusPath)
+	if err != nil {
+		return virtualMemory, err
+	}
+	defer status.Close()
+
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != virtualMemoryField {
+			continue
+		}
+
+		virtualMemory, err = strconv.ParseUint(fields[1], 10, 64)
+		return virtualMemory * 1024, err
+	}
+
+	return virtualMemory, scanner.Err()
+}
+
+// setLimits limit the memory and the CPU time of the current process. The Go runtime reserves a lot of
+// virtual memory when starting, so the memory limit is added to the one already reserved
+func setLimits(limits Limits) (err error) {
+	if limits.MaxMemoryBytes > 0 {
+		virtualMemory, err := getVirtualMemory()
+		if err != nil {
+			return err
+		}
+
+		memoryLimit := virtualMemory + limits.MaxMemoryBytes
+		err = syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: memoryLimit, Max: memoryLimit})
+		if err != nil {
+			return err
+		}
+
+		// Make the garbage collector work harder before reaching the limit, instead of crashing
+		debug.SetMemoryLimit(int64(limits.MaxMemoryBytes))
+	}
+
+	if limits.Timeout > 0 {
+		cpuLimit := uint64(math.Ceil(limits.Timeout.Seconds()))
+		err = syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: cpuLimit, Max: cpuLimit})
+	}
+
+	return err
+}
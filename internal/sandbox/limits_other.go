@@ -0,0 +1,16 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"runtime/debug"
+)
+
+// setLimits limit the memory of the current process. Only the garbage collector is told about the limit,
+// so processes are stopped only by the timeout on other systems than Linux
+func setLimits(limits Limits) (err error) {
+	if limits.MaxMemoryBytes > 0 {
+		debug.SetMemoryLimit(int64(limits.MaxMemoryBytes))
+	}
+	return err
+}
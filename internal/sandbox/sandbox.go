@@ -0,0 +1,202 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// handlerEnvironmentVariable is the variable telling a process that it is a sandbox, and which handler it runs
+	handlerEnvironmentVariable = "REFORMA_SANDBOX_HANDLER"
+
+	// maxErrorBytes is the size of the errors read from sandbox processes at most
+	maxErrorBytes = 4096
+
+	// unknownHandlerError error message for sandbox processes started for handlers not registered
+	unknownHandlerError = "unknown sandbox handler: %s"
+
+	// outputTooLargeError error message for handlers producing outputs larger than allowed
+	outputTooLargeError = "output is larger than %d bytes"
+
+	// processFailedError error message for sandbox processes ending without an answer, like when running out of memory
+	processFailedError = "sandbox process failed: %v: %s"
+)
+
+var (
+	// ErrTimeout is returned when a handler does not finish in time, and its process is killed
+	ErrTimeout = errors.New("sandbox process did not finish in time")
+
+	// handlers store the functions that can be run in a sandbox, by their name
+	handlers = map[string]Handler{}
+)
+
+// Handler is a function run in a sandbox process, receiving an input and returning its output
+type Handler func(input []byte) (output []byte, err error)
+
+// Limits define the resources a handler can use at most
+type Limits struct {
+
+	// Timeout is the time the handler can run at most, before killing its process
+	Timeout time.Duration
+
+	// MaxMemoryBytes is the memory the handler can reserve at most, on top of the one the process starts with
+	MaxMemoryBytes uint64
+
+	// MaxOutputBytes is the size of the output at most
+	MaxOutputBytes int
+}
+
+// request is what a sandbox process reads from its standard input
+type request struct {
+	Limits Limits `json:"limits"`
+	Input  []byte `json:"input"`
+}
+
+// response is what a sandbox process writes to its standard output
+type response struct {
+	Output []byte `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// limitedBuffer is a buffer killing the process writing to it when it grows over the limit
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	exceeded bool
+	kill     context.CancelFunc
+}
+
+// Write store the data written, unless the buffer grows over its limit
+func (b *limitedBuffer) Write(data []byte) (n int, err error) {
+	if b.Len()+len(data) > b.limit {
+		b.exceeded = true
+		b.kill()
+		return n, io.ErrShortWrite
+	}
+	return b.Buffer.Write(data)
+}
+
+// Register make a handler available to be run in sandbox processes. It must be called before Init,
+// so it is usually called from the init function of the package owning the handler
+func Register(name string, handler Handler) {
+	handlers[name] = handler
+}
+
+// Init run the requested handler and exit when the current process is a sandbox, doing nothing otherwise.
+// It must be called at the very beginning of the main function of the binary, as sandboxes are copies of it
+func Init() {
+	name, isSandbox := os.LookupEnv(handlerEnvironmentVariable)
+	if !isSandbox {
+		return
+	}
+
+	err := json.NewEncoder(os.Stdout).Encode(serve(name, os.Stdin))
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// serve run a handler with the request read from the input, under the limits of the request
+func serve(name string, input io.Reader) (result response) {
+	handler, ok := handlers[name]
+	if !ok {
+		return response{Error: fmt.Sprintf(unknownHandlerError, name)}
+	}
+
+	rawRequest, err := io.ReadAll(input)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+
+	handlerRequest := request{}
+	err = json.Unmarshal(rawRequest, &handlerRequest)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+
+	err = setLimits(handlerRequest.Limits)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+
+	output, err := handler(handlerRequest.Input)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+
+	if len(output) > handlerRequest.Limits.MaxOutputBytes {
+		return response{Error: fmt.Sprintf(outputTooLargeError, handlerRequest.Limits.MaxOutputBytes)}
+	}
+
+	return response{Output: output}
+}
+
+// Run run a handler in a new process of the current binary, killing it when it exceeds the limits.
+// The process does not inherit the environment, so handlers can not read the variables of the caller
+func Run(ctx context.Context, name string, input []byte, limits Limits) (output []byte, err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return output, err
+	}
+
+	rawRequest, err := json.Marshal(request{Limits: limits, Input: input})
+	if err != nil {
+		return output, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	// The output is encoded in base64 inside the response, so it takes more room than the limit
+	stdout := &limitedBuffer{limit: 2*limits.MaxOutputBytes + maxErrorBytes, kill: cancel}
+	stderr := &limitedBuffer{limit: maxErrorBytes, kill: func() {}}
+
+	command := exec.CommandContext(ctx, executable)
+	command.Env = []string{handlerEnvironmentVariable + "=" + name}
+	command.Stdin = bytes.NewReader(rawRequest)
+	command.Stdout = stdout
+	command.Stderr = stderr
+
+	err = command.Run()
+	switch {
+	case stdout.exceeded:
+		return output, fmt.Errorf(outputTooLargeError, limits.MaxOutputBytes)
+	case ctx.Err() != nil:
+		return output, ErrTimeout
+	case err != nil:
+		return output, fmt.Errorf(processFailedError, err, getFirstLine(stderr.String()))
+	}
+
+	handlerResponse := response{}
+	err = json.Unmarshal(stdout.Bytes(), &handlerResponse)
+	if err != nil {
+		return output, err
+	}
+
+	if handlerResponse.Error != "" {
+		return output, errors.New(handlerResponse.Error)
+	}
+
+	return handlerResponse.Output, err
+}
+
+// getFirstLine return the first line of a text that is not empty, which explains why a process crashed
+func getFirstLine(text string) (line string) {
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		if line = strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return line
+}
@@ -0,0 +1,126 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// allocated keep the memory allocated by the tests, so it is not collected
+var allocated [][]byte
+
+func TestMain(m *testing.M) {
+	Register("echo", func(input []byte) (output []byte, err error) {
+		return input, err
+	})
+	Register("fail", func(input []byte) (output []byte, err error) {
+		return output, errors.New(string(input))
+	})
+	Register("allocate", func(input []byte) (output []byte, err error) {
+		megabytes, err := strconv.Atoi(string(input))
+		for i := 0; i < megabytes; i++ {
+			allocated = append(allocated, bytes.Repeat([]byte{1}, 1<<20))
+		}
+		return output, err
+	})
+	Register("loop", func(input []byte) (output []byte, err error) {
+		for {
+			output = append(output[:0], input...)
+		}
+	})
+	Register("environment", func(input []byte) (output []byte, err error) {
+		return []byte(os.Getenv(string(input))), err
+	})
+
+	Init()
+	os.Exit(m.Run())
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("SANDBOX_TEST_SECRET", "secret")
+
+	limits := Limits{Timeout: 5 * time.Second, MaxMemoryBytes: 128 << 20, MaxOutputBytes: 1024}
+
+	tests := []struct {
+		name       string
+		handler    string
+		input      string
+		limits     Limits
+		wantOutput string
+		wantErr    string
+	}{
+		{
+			name:       "output of the handler",
+			handler:    "echo",
+			input:      "hello",
+			limits:     limits,
+			wantOutput: "hello",
+		},
+		{
+			name:    "error of the handler",
+			handler: "fail",
+			input:   "failed",
+			limits:  limits,
+			wantErr: "failed",
+		},
+		{
+			name:    "unknown handler",
+			handler: "unknown",
+			limits:  limits,
+			wantErr: "unknown sandbox handler: unknown",
+		},
+		{
+			name:    "memory under the limit",
+			handler: "allocate",
+			input:   "16",
+			limits:  limits,
+		},
+		{
+			name:    "memory over the limit",
+			handler: "allocate",
+			input:   "512",
+			limits:  limits,
+			wantErr: "out of memory",
+		},
+		{
+			name:    "endless handler",
+			handler: "loop",
+			limits:  Limits{Timeout: 500 * time.Millisecond, MaxOutputBytes: 1024},
+			wantErr: ErrTimeout.Error(),
+		},
+		{
+			name:    "output over the limit",
+			handler: "echo",
+			input:   strings.Repeat("a", 2048),
+			limits:  limits,
+			wantErr: "output is larger than 1024 bytes",
+		},
+		{
+			name:    "environment is not inherited",
+			handler: "environment",
+			input:   "SANDBOX_TEST_SECRET",
+			limits:  limits,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := Run(context.Background(), test.handler, []byte(test.input), test.limits)
+
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("got error %v, want it to contain %q", err, test.wantErr)
+			}
+			if string(output) != test.wantOutput {
+				t.Errorf("got output %q, want %q", output, test.wantOutput)
+			}
+		})
+	}
+}
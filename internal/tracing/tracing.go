@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+const (
+	// serviceName is the name identifying the controller in the exported traces
+	serviceName = "reforma"
+)
+
+// Options defines how the traces are exported
+type Options struct {
+
+	// Endpoint is the address of the OTLP gRPC collector receiving the traces. Tracing is disabled when empty
+	Endpoint string
+
+	// Insecure disables TLS on the connection to the collector, as local collectors usually do not use it
+	Insecure bool
+
+	// SamplingRatio is the ratio of reconciliations traced, from 0 to 1
+	SamplingRatio float64
+}
+
+// Setup configure the global tracer provider to export the traces to an OTLP collector.
+// It returns a function to flush the pending traces on shutdown, that does nothing when tracing is disabled
+func Setup(ctx context.Context, options Options) (shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+
+	if options.Endpoint == "" {
+		return shutdown, err
+	}
+
+	exporterOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		exporterOptions = append(exporterOptions, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, exporterOptions...)
+	if err != nil {
+		return shutdown, err
+	}
+
+	traceResource, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return shutdown, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(traceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SamplingRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, err
+}